@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	backendutils "github.com/rayanebel/kubeseal-backuper/pkg/utils/backend"
+	catalogutils "github.com/rayanebel/kubeseal-backuper/pkg/utils/catalog"
+	k8sutils "github.com/rayanebel/kubeseal-backuper/pkg/utils/kube"
+	restoreutils "github.com/rayanebel/kubeseal-backuper/pkg/utils/restore"
+	log "github.com/sirupsen/logrus"
+)
+
+// command - A subcommand of the CLI.
+type command struct {
+	name        string
+	description string
+	// flags - Register the flags specific to the command, their defaults come from the environment.
+	flags func(fs *flag.FlagSet)
+	run   func(ctx context.Context)
+}
+
+var listOutput string
+
+// parsedFlags - Flags of the command being run.
+var parsedFlags *flag.FlagSet
+
+var commands = []command{
+	{
+		name:        "backup",
+		description: "Back up every kubeseal key, then decommission the old ones if enabled",
+		flags:       backupFlags,
+		run: func(ctx context.Context) {
+			k8sutils.SetKubernetesclient(state)
+			storage := backendutils.InitBackend(state)
+			defer backendutils.CloseBackend(storage)
+			backup(ctx, storage)
+		},
+	},
+	{
+		name:        "watch",
+		description: "Back up every new key as soon as the controller creates it, until stopped",
+		flags: func(fs *flag.FlagSet) {
+			fs.DurationVar(&state.Config.WatchResync, "resync", state.Config.WatchResync, "Period at which a failed backup is retried")
+			fs.BoolVar(&state.Config.Decommission, "decommission", state.Config.Decommission, "Decommission the old keys after each backup")
+		},
+		run: func(ctx context.Context) {
+			k8sutils.SetKubernetesclient(state)
+			watch(forwardedFlags(backupFlags))
+		},
+	},
+	{
+		name:        "rotate",
+		description: "Generate a new key, back it up, import it into the cluster, then decommission the old keys if enabled",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&state.Config.Decommission, "decommission", state.Config.Decommission, "Decommission the old keys after the rotation")
+			fs.StringVar(&state.Config.DecommissionPolicy, "decommission-policy", state.Config.DecommissionPolicy, "When to decommission: on-new-key or always")
+		},
+		run: func(ctx context.Context) {
+			k8sutils.SetKubernetesclient(state)
+			storage := backendutils.InitBackend(state)
+			defer backendutils.CloseBackend(storage)
+			rotate(ctx, storage)
+		},
+	},
+	{
+		name:        "generate-key",
+		description: "Generate a new key, back it up, then import it into the cluster",
+		run: func(ctx context.Context) {
+			k8sutils.SetKubernetesclient(state)
+			storage := backendutils.InitBackend(state)
+			defer backendutils.CloseBackend(storage)
+			generateKey(ctx, storage)
+		},
+	},
+	{
+		name:        "restore",
+		description: "Re-import backed-up keys into the cluster and restart the controller",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&state.Config.RestoreKeys, "keys", state.Config.RestoreKeys, "Keys to restore: all, fingerprint:<sha256> or date:<YYYY-MM-DD>")
+		},
+		run: func(ctx context.Context) {
+			k8sutils.SetKubernetesclient(state)
+			storage := backendutils.InitBackend(state)
+			defer backendutils.CloseBackend(storage)
+			restored := restoreutils.RestoreKeys(ctx, state, storage)
+			notify(restoreMessage(restored))
+		},
+	},
+	{
+		name:        "verify",
+		description: "Check that the backups are readable and that every key of the cluster is backed up",
+		run: func(ctx context.Context) {
+			k8sutils.SetKubernetesclient(state)
+			storage := backendutils.InitBackend(state)
+			failed := catalogutils.VerifyBackups(ctx, state, storage)
+			backendutils.CloseBackend(storage)
+			if failed > 0 {
+				os.Exit(1)
+			}
+		},
+	},
+	{
+		name:        "list",
+		description: "List the backed-up keys",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&listOutput, "output", "table", "Output format: table or json")
+		},
+		run: func(ctx context.Context) {
+			// Keep stdout for the listing.
+			log.SetOutput(os.Stderr)
+			storage := backendutils.InitBackend(state)
+			defer backendutils.CloseBackend(storage)
+			catalogutils.ListKeys(ctx, state, storage, listOutput, os.Stdout)
+		},
+	},
+	{
+		name:        "prune",
+		description: "Drop missing backups from the catalog and delete backups of decommissioned or listed keys",
+		flags: func(fs *flag.FlagSet) {
+			fs.DurationVar(&state.Config.PruneOlderThan, "older-than", state.Config.PruneOlderThan, "Delete backups of keys decommissioned in the cluster and created before this duration, 0 to keep them")
+			fs.StringVar(&state.Config.PruneFingerprints, "fingerprints", state.Config.PruneFingerprints, "Comma separated fingerprints of the keys whose backups are deleted")
+		},
+		run: func(ctx context.Context) {
+			if state.Config.PruneOlderThan > 0 {
+				k8sutils.SetKubernetesclient(state)
+			}
+			storage := backendutils.InitBackend(state)
+			defer backendutils.CloseBackend(storage)
+			var fingerprints []string
+			for _, fingerprint := range strings.Split(state.Config.PruneFingerprints, ",") {
+				if fingerprint = strings.TrimSpace(fingerprint); fingerprint != "" {
+					fingerprints = append(fingerprints, fingerprint)
+				}
+			}
+			pruned := catalogutils.PruneBackups(ctx, state, storage, state.Config.PruneOlderThan, fingerprints)
+			log.WithFields(log.Fields{
+				"pruned": pruned,
+			}).Info("Backups have been pruned")
+		},
+	},
+	{
+		name:        "decommission",
+		description: "Mark the old keys as compromised and restart the controller",
+		flags: func(fs *flag.FlagSet) {
+			fs.IntVar(&state.Config.DecommissionKeepLatest, "keep-latest", state.Config.DecommissionKeepLatest, "Number of newest keys kept active")
+			fs.DurationVar(&state.Config.DecommissionGracePeriod, "grace-period", state.Config.DecommissionGracePeriod, "Keep a key active for this duration after a newer key appears")
+		},
+		run: func(ctx context.Context) {
+			k8sutils.SetKubernetesclient(state)
+			decommissioned := k8sutils.CleanSecret(state)
+			if decommissioned > 0 {
+				notify(decommissionMessage(decommissioned))
+			}
+		},
+	},
+}
+
+// commonFlags - will register the flags shared by every command.
+func commonFlags(fs *flag.FlagSet) {
+	conf := state.Config
+	fs.StringVar(&conf.KubesealControllerNamespace, "namespace", conf.KubesealControllerNamespace, "Namespace of the kubeseal controller")
+	fs.StringVar(&conf.KubesealControllerName, "controller", conf.KubesealControllerName, "Name of the kubeseal controller")
+	fs.StringVar(&conf.KubesealKeyPrefix, "key-prefix", conf.KubesealKeyPrefix, "Name prefix of the kubeseal key secrets")
+	fs.StringVar(&conf.KubesealPodSelector, "pod-selector", conf.KubesealPodSelector, "Label selector of the kubeseal controller pods, defaults to the selector of the controller deployment")
+	fs.StringVar(&conf.KubesealRestartStrategy, "restart-strategy", conf.KubesealRestartStrategy, "How to restart the kubeseal controller: delete or rollout")
+	fs.BoolVar(&conf.KubesealVerifyRestart, "verify-restart", conf.KubesealVerifyRestart, "Check that the restarted controller serves the newest certificate")
+	fs.StringVar(&conf.ClusterName, "cluster", conf.ClusterName, "Name of the cluster recorded with the backups")
+	fs.StringVar(&conf.Backend, "backend", conf.Backend, "Comma separated list of storage backends")
+	fs.StringVar(&conf.BackendPolicy, "backend-policy", conf.BackendPolicy, "Success policy of multiple backends: all, any or quorum:N")
+	fs.StringVar(&conf.CatalogName, "catalog", conf.CatalogName, "Name of the backup catalog, empty to disable it")
+	fs.StringVar(&conf.Encryption, "encryption", conf.Encryption, "Client-side encryption provider")
+	fs.BoolVar(&conf.DryRun, "dry-run", conf.DryRun, "Log the changes instead of performing them")
+	fs.StringVar(&conf.Notifier, "notifier", conf.Notifier, "Notifier backend")
+	fs.StringVar(&conf.KubernetesKubeconfigPath, "kubeconfig", conf.KubernetesKubeconfigPath, "Path of a kubeconfig file, switches to the external client mode")
+}
+
+// backupFlags - will register the flags of the backup command.
+func backupFlags(fs *flag.FlagSet) {
+	conf := state.Config
+	fs.StringVar(&conf.KeyNameTemplate, "key-name-template", conf.KeyNameTemplate, "Template of the key object names")
+	fs.StringVar(&conf.BundleNameTemplate, "bundle-name-template", conf.BundleNameTemplate, "Template of the bundle object names, empty to disable the bundle")
+	fs.BoolVar(&conf.Decommission, "decommission", conf.Decommission, "Decommission the old keys after the backup")
+	fs.StringVar(&conf.DecommissionPolicy, "decommission-policy", conf.DecommissionPolicy, "When to decommission: on-new-key or always")
+	fs.IntVar(&conf.DecommissionKeepLatest, "keep-latest", conf.DecommissionKeepLatest, "Number of newest keys kept active")
+	fs.DurationVar(&conf.DecommissionGracePeriod, "grace-period", conf.DecommissionGracePeriod, "Keep a key active for this duration after a newer key appears")
+}
+
+// forwardedFlags - will return the flags set on the command line which are registered by flags too, to pass them
+// to a child process.
+func forwardedFlags(flags func(fs *flag.FlagSet)) []string {
+	target := flag.NewFlagSet("forwarded", flag.ContinueOnError)
+	commonFlags(target)
+	flags(target)
+	var args []string
+	parsedFlags.Visit(func(f *flag.Flag) {
+		if target.Lookup(f.Name) != nil {
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	return args
+}
+
+// usage - will print the available commands.
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Without command, the flow selected by MODE (backup, restore or watch) is run.\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' to list the flags of a command. Flags default to the environment variables.\n", os.Args[0])
+}
+
+// runCommand - will parse the flags of the named command and execute it.
+func runCommand(name string, args []string) {
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+		commonFlags(fs)
+		if cmd.flags != nil {
+			cmd.flags(fs)
+		}
+		fs.Parse(args)
+		parsedFlags = fs
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "kubeconfig" {
+				state.Config.KubernetesClientMode = "external"
+			}
+		})
+		if fs.NArg() > 0 {
+			fmt.Fprintf(os.Stderr, "Unexpected arguments: %v\n", fs.Args())
+			fs.Usage()
+			os.Exit(2)
+		}
+		cmd.run(context.Background())
+		return
+	}
+	switch name {
+	case "help", "-h", "-help", "--help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %s\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+}
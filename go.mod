@@ -3,13 +3,16 @@ module github.com/rayanebel/kubeseal-backuper
 go 1.13
 
 require (
+	filippo.io/age v1.0.0
 	github.com/aws/aws-sdk-go v1.27.4
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/nlopes/slack v0.6.0
 	github.com/sirupsen/logrus v1.4.2
-	golang.org/x/sys v0.0.0-20191008105621-543471e840be // indirect
+	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
+	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
 	k8s.io/api v0.0.0-20190918155943-95b840bb6a1f
 	k8s.io/apimachinery v0.0.0-20190913080033-27d36303b655
 	k8s.io/client-go v0.0.0-20190918160344-1fbdaa4c8d90
 	sigs.k8s.io/controller-runtime v0.4.0
+	sigs.k8s.io/yaml v1.1.0
 )
@@ -1,23 +1,37 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/nlopes/slack"
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+	"github.com/rayanebel/kubeseal-backuper/pkg/catalog"
 	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+	"github.com/rayanebel/kubeseal-backuper/pkg/encryption"
 
 	slackclient "github.com/rayanebel/kubeseal-backuper/pkg/notifiers/slack"
 
+	backendutils "github.com/rayanebel/kubeseal-backuper/pkg/utils/backend"
+	encryptionutils "github.com/rayanebel/kubeseal-backuper/pkg/utils/encryption"
 	k8sutils "github.com/rayanebel/kubeseal-backuper/pkg/utils/kube"
-	s3utils "github.com/rayanebel/kubeseal-backuper/pkg/utils/s3"
+	restoreutils "github.com/rayanebel/kubeseal-backuper/pkg/utils/restore"
 	slackutils "github.com/rayanebel/kubeseal-backuper/pkg/utils/slack"
 
 	"github.com/rayanebel/kubeseal-backuper/pkg/utils/kubeseal"
 
 	"github.com/kelseyhightower/envconfig"
 	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -29,16 +43,276 @@ const (
 
 var state *config.State
 
-// run - will execute all steps to backup and clean sealed secret key.
+// run - will execute all steps of the selected mode. It is the default flow when no command is given.
 func run() {
+	ctx := context.Background()
 	k8sutils.SetKubernetesclient(state)
-	processBackup(state)
-	k8sutils.CleanSecret(state)
+	if state.Config.Mode == "watch" {
+		// The backups run in child processes, which init their own backend.
+		watch(nil)
+		return
+	}
+	storage := backendutils.InitBackend(state)
+	defer backendutils.CloseBackend(storage)
+
+	switch state.Config.Mode {
+	case "backup":
+		backup(ctx, storage)
+	case "restore":
+		restored := restoreutils.RestoreKeys(ctx, state, storage)
+		notify(restoreMessage(restored))
+	default:
+		log.WithFields(log.Fields{
+			"mode": state.Config.Mode,
+		}).Error("Unsupported mode")
+		os.Exit(1)
+	}
+}
+
+// backup - will back up the keys, then decommission the old ones when DECOMMISSION is enabled and
+// DECOMMISSION_POLICY allows it.
+func backup(ctx context.Context, storage backend.Backend) {
+	enc := encryptionutils.InitEncryption(state)
+	uploaded := processBackup(ctx, state, storage, enc)
+	decommissioned := 0
+	if shouldDecommission(uploaded) {
+		decommissioned = k8sutils.CleanSecret(state)
+	}
+
+	switch {
+	case uploaded > 0 && decommissioned > 0:
+		notify(backupMessage(decommissioned))
+	case uploaded > 0:
+		notify(uploadMessage(uploaded))
+	case decommissioned > 0:
+		notify(decommissionMessage(decommissioned))
+	default:
+		log.WithFields(log.Fields{}).Info("No new key has been backed up, nothing to notify")
+	}
+}
+
+// watch - will back up the keys each time the kubeseal controller creates one or replaces the certificate of one,
+// until the process is stopped. Every backup runs the backup command in a child process: an error exits it, is logged,
+// and the backup is retried at every WATCH_RESYNC period instead of stopping the watch.
+func watch(args []string) {
+	// Events are coalesced: a backup covers every key, so a pending one is enough.
+	trigger := make(chan struct{}, 1)
+	handler := func(old *v1.Secret, secret *v1.Secret) {
+		if !strings.HasPrefix(secret.Name, state.Config.KubesealKeyPrefix) {
+			return
+		}
+		// Resyncs and label changes, such as a decommission, leave the key as it is.
+		if old != nil {
+			previous, _ := kubeseal.CertificateFingerprint(*old)
+			current, err := kubeseal.CertificateFingerprint(*secret)
+			if err == nil && previous == current {
+				return
+			}
+		}
+		log.WithFields(log.Fields{
+			"secret": secret.Name,
+		}).Info("New kubeseal key detected")
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.WithFields(log.Fields{
+			"signal": sig.String(),
+		}).Info("Stopping watch")
+		close(stop)
+	}()
+
+	err := state.K8s.WatchSecrets(state.Config.KubesealControllerNamespace, kubesealSecretLabel, handler, stop)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":     err.Error(),
+			"namespace": state.Config.KubesealControllerNamespace,
+		}).Error("Unable to watch kubeseal keys")
+		os.Exit(1)
+	}
+	log.WithFields(log.Fields{
+		"namespace": state.Config.KubesealControllerNamespace,
+	}).Info("Watching kubeseal keys")
+
+	ticker := time.NewTicker(state.Config.WatchResync)
+	defer ticker.Stop()
+	failed := false
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if !failed {
+				continue
+			}
+		case <-trigger:
+		}
+		err := backupProcess(args)
+		failed = err != nil
+		if failed {
+			log.WithFields(log.Fields{
+				"error": err.Error(),
+				"retry": state.Config.WatchResync,
+			}).Error("Backup failed, it will be retried")
+		}
+	}
+}
+
+// backupProcess - will run the backup command in a child process, with the given flags and the environment of the
+// current one.
+func backupProcess(args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, append([]string{"backup"}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// generateKey - will generate a new key, back it up, then create it in the cluster and restart the controller so it
+// seals with it.
+func generateKey(ctx context.Context, storage backend.Backend) {
+	enc := encryptionutils.InitEncryption(state)
+	name := createKey(ctx, storage, enc)
+	notify(generateKeyMessage(name))
+}
+
+// rotate - will create a new key, back up the keys of the cluster, then decommission the old ones when DECOMMISSION is
+// enabled and DECOMMISSION_POLICY allows it. The created key counts as a new key for the policy.
+func rotate(ctx context.Context, storage backend.Backend) {
+	enc := encryptionutils.InitEncryption(state)
+	name := createKey(ctx, storage, enc)
+	// The new key has already been stored, the backup refreshes the bundle and catches keys missed before.
+	uploaded := processBackup(ctx, state, storage, enc)
+	decommissioned := 0
+	if shouldDecommission(uploaded + 1) {
+		decommissioned = k8sutils.CleanSecret(state)
+	}
+	notify(rotateMessage(name, decommissioned))
+}
+
+// createKey - will generate a new key, back it up, then create it in the cluster and restart the controller so it
+// seals with it. The key is only created once its backup succeeded. It returns the name of the new key.
+func createKey(ctx context.Context, storage backend.Backend, enc encryption.Encrypter) string {
+	secret, err := kubeseal.GenerateKeySecret(state.Config.KubesealControllerNamespace, state.Config.KubesealKeyPrefix)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Error("Unable to generate kubeseal key")
+		os.Exit(1)
+	}
+	log.WithFields(log.Fields{
+		"secret": secret.Name,
+	}).Info("New kubeseal key has been generated")
+
+	manifest := exportSecret(*secret)
+	entry := backendutils.StoreSecretKey(ctx, state, storage, enc, *secret, secret.CreationTimestamp.Time, bytes.NewReader(manifest))
+	if state.Config.CatalogName != "" {
+		backendutils.UpdateCatalog(ctx, state, storage, []catalog.Entry{entry}, nil)
+	}
+
+	// The creation date is set by the API server.
+	secret.CreationTimestamp = metav1.Time{}
+	_, err = state.K8s.CreateSecret(secret)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err.Error(),
+			"secret": secret.Name,
+		}).Error("Unable to create kubeseal key, it has been backed up but is not used")
+		os.Exit(1)
+	}
+	log.WithFields(log.Fields{
+		"secret":    secret.Name,
+		"namespace": secret.Namespace,
+	}).Info("New kubeseal key has been created")
+	k8sutils.RestartKubeseal(state)
+	return secret.Name
+}
+
+// shouldDecommission - will check whether the old keys must be decommissioned after a backup which uploaded
+// the given number of new keys.
+func shouldDecommission(uploaded int) bool {
+	if !state.Config.Decommission {
+		log.WithFields(log.Fields{}).Info("Decommission is disabled, old keys are kept active")
+		return false
+	}
+	switch state.Config.DecommissionPolicy {
+	case "always":
+		return true
+	case "on-new-key":
+		if uploaded == 0 {
+			log.WithFields(log.Fields{
+				"policy": state.Config.DecommissionPolicy,
+			}).Info("No new key has been backed up, old keys are kept active")
+			return false
+		}
+		return true
+	default:
+		log.WithFields(log.Fields{
+			"policy": state.Config.DecommissionPolicy,
+		}).Error("Unsupported decommission policy")
+		os.Exit(1)
+	}
+	return false
+}
+
+// backupMessage - will describe a backup followed by the decommission of the old keys.
+func backupMessage(decommissioned int) string {
+	return fmt.Sprintf("*Kubeseal controller*: `%s` has generated a new encryption key."+
+		" This Key has been upload to %s."+
+		" %d old encryption key(s) have been *decommissioned*."+
+		" Please *re-encrypt* all your secret using the new key.", state.Config.KubesealControllerName, state.Config.Backend, decommissioned)
+}
+
+// uploadMessage - will describe a backup which left the old keys active.
+func uploadMessage(uploaded int) string {
+	return fmt.Sprintf("*Kubeseal controller*: %d new encryption key(s) of `%s` have been upload to %s."+
+		" The old encryption keys are still active.", uploaded, state.Config.KubesealControllerName, state.Config.Backend)
+}
 
-	msgTxt := fmt.Sprintf("*Kubeseal controller*: `%s` has generated a new encryption key."+
-		" This Key has been upload to S3."+
-		" The old encryption keys have all been *decommissioned*."+
-		" Please *re-encrypt* all your secret using the new key.", state.Config.KubesealControllerName)
+// generateKeyMessage - will describe the import of a generated key.
+func generateKeyMessage(name string) string {
+	return fmt.Sprintf("*Kubeseal controller*: a new encryption key `%s` has been generated for `%s` and upload to %s."+
+		" Please *re-encrypt* all your secret using the new key.", name, state.Config.KubesealControllerName, state.Config.Backend)
+}
+
+// rotateMessage - will describe a rotation, followed or not by the decommission of the old keys.
+func rotateMessage(name string, decommissioned int) string {
+	if decommissioned == 0 {
+		return generateKeyMessage(name) + " The old encryption keys are still active."
+	}
+	return generateKeyMessage(name) + fmt.Sprintf(" %d old encryption key(s) have been *decommissioned*.", decommissioned)
+}
+
+// decommissionMessage - will describe the decommission of the old keys.
+func decommissionMessage(decommissioned int) string {
+	return fmt.Sprintf("*Kubeseal controller*: %d old encryption key(s) of `%s` have been *decommissioned*."+
+		" Please *re-encrypt* all your secret using the new key.", decommissioned, state.Config.KubesealControllerName)
+}
+
+// restoreMessage - will describe a restore.
+func restoreMessage(restored int) string {
+	return fmt.Sprintf("*Kubeseal controller*: %d encryption key(s) of `%s` have been *restored* from %s.",
+		restored, state.Config.KubesealControllerName, state.Config.Backend)
+}
+
+// notify - will send msgTxt through the configured notifier.
+func notify(msgTxt string) {
+	if state.Config.DryRun {
+		log.WithFields(log.Fields{
+			"notifier": state.Config.Notifier,
+		}).Info("Dry run: notification would be sent")
+		return
+	}
 	switch state.Config.Notifier {
 	case "slack":
 		slackMsg := slackclient.SlackMessage{
@@ -59,8 +333,9 @@ func run() {
 	}
 }
 
-// processBackup - will process the backup of sealedsecret and export it into an external storage endpoint.
-func processBackup(state *config.State) {
+// processBackup - will process the backup of sealedsecret and export it into an external storage endpoint. It returns
+// the number of keys which were not backed up yet.
+func processBackup(ctx context.Context, state *config.State, storage backend.Backend, enc encryption.Encrypter) int {
 	labelSelector := kubesealSecretLabel
 	opts := metav1.ListOptions{
 		LabelSelector: labelSelector,
@@ -75,7 +350,7 @@ func processBackup(state *config.State) {
 		os.Exit(1)
 	}
 
-	secret, err := kubeseal.FindSecretByPrefix(secrets, state.Config.KubesealKeyPrefix)
+	kubesealSecrets, err := kubeseal.FindSecretsByPrefix(secrets, state.Config.KubesealKeyPrefix)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err.Error(),
@@ -83,6 +358,34 @@ func processBackup(state *config.State) {
 		os.Exit(1)
 	}
 
+	var bundle bytes.Buffer
+	var created time.Time
+	var entries []catalog.Entry
+	var bundles []catalog.Bundle
+	uploaded := 0
+	for _, secret := range kubesealSecrets {
+		// The creation date is cleaned from the exported manifest but is used to name the backup.
+		created = secret.CreationTimestamp.Time
+		manifest := exportSecret(secret)
+		entry := backendutils.StoreSecretKey(ctx, state, storage, enc, secret, created, bytes.NewReader(manifest))
+		entries = append(entries, entry)
+		if entry.Checksum != "" {
+			uploaded++
+		}
+		bundle.WriteString("---\n")
+		bundle.Write(manifest)
+	}
+	if state.Config.BundleNameTemplate != "" {
+		bundles = append(bundles, backendutils.StoreBundle(ctx, state, storage, enc, kubesealSecrets, created, &bundle))
+	}
+	if state.Config.CatalogName != "" {
+		backendutils.UpdateCatalog(ctx, state, storage, entries, bundles)
+	}
+	return uploaded
+}
+
+// exportSecret - will clean a kubeseal secret from its cluster specific fields and return its yaml manifest.
+func exportSecret(secret v1.Secret) []byte {
 	k8sutils.SetGVKForObject(&secret)
 
 	var obj unstructured.Unstructured
@@ -118,19 +421,17 @@ func processBackup(state *config.State) {
 		}).Error("Unable to convert secret object into yaml")
 		os.Exit(1)
 	}
+	defer os.Remove(fileName)
 
-	kubesealYamlfile, err := os.Open(fileName)
+	manifest, err := ioutil.ReadFile(fileName)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error":    err.Error(),
 			"filename": fileName,
-		}).Error("Unable to open temporary file")
+		}).Error("Unable to read temporary file")
 		os.Exit(1)
 	}
-	defer kubesealYamlfile.Close()
-
-	s3utils.StoreSecretKeyToS3(state, kubesealYamlfile)
-
+	return manifest
 }
 
 // main program
@@ -147,7 +448,11 @@ func main() {
 	config.NewState()
 	state = config.GetState()
 	state.Config = conf
-	run()
+	if len(os.Args) < 2 {
+		run()
+		return
+	}
+	runCommand(os.Args[1], os.Args[2:])
 }
 
 // init function
@@ -0,0 +1,341 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+)
+
+const (
+	apiVersion     = "2019-02-02"
+	metadataHeader = "x-ms-meta-"
+	requestTimeout = 30 * time.Second
+)
+
+// Options - Settings used to init the Azure Blob backend.
+type Options struct {
+	Account   string
+	Container string
+	Prefix    string
+	// Endpoint - Blob service endpoint, e.g. http://127.0.0.1:10000/devstoreaccount1 for Azurite.
+	Endpoint string
+	// AccountKey - Base64 encoded shared key. Ignored when SASToken is set.
+	AccountKey string
+	SASToken   string
+	// AccessTier - Hot, Cool or Archive. Empty means the account default tier.
+	AccessTier string
+}
+
+// Backend - Azure Blob Storage implementation of backend.Backend using the REST API.
+type Backend struct {
+	Client  *http.Client
+	Options *Options
+	key     []byte
+	sas     url.Values
+}
+
+type blobList struct {
+	Blobs []struct {
+		Name       string `xml:"Name"`
+		Properties struct {
+			LastModified  string `xml:"Last-Modified"`
+			ContentLength int64  `xml:"Content-Length"`
+		} `xml:"Properties"`
+	} `xml:"Blobs>Blob"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+func init() {
+	backend.Register("azure", NewBackend)
+}
+
+// New - Init an Azure Blob backend.
+func New(opts *Options) (*Backend, error) {
+	if opts.Account == "" || opts.Container == "" {
+		return nil, fmt.Errorf("Storage account and container are required")
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", opts.Account)
+	}
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+
+	b := &Backend{Client: &http.Client{Timeout: requestTimeout}, Options: opts}
+	switch {
+	case opts.SASToken != "":
+		sas, err := url.ParseQuery(strings.TrimPrefix(opts.SASToken, "?"))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid SAS token: %s", err.Error())
+		}
+		b.sas = sas
+	case opts.AccountKey != "":
+		key, err := base64.StdEncoding.DecodeString(opts.AccountKey)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid storage account key: %s", err.Error())
+		}
+		b.key = key
+	default:
+		return nil, fmt.Errorf("A storage account key or a SAS token is required")
+	}
+	switch opts.AccessTier {
+	case "", "Hot", "Cool", "Archive":
+	default:
+		return nil, fmt.Errorf("Unsupported access tier %s", opts.AccessTier)
+	}
+	return b, nil
+}
+
+// NewBackend - Init the Azure Blob backend from the current state.
+func NewBackend(state *config.State) (backend.Backend, error) {
+	return New(&Options{
+		Account:    state.Config.AzureStorageAccount,
+		Container:  state.Config.AzureContainerName,
+		Prefix:     state.Config.AzurePrefix,
+		Endpoint:   state.Config.AzureEndpoint,
+		AccountKey: state.Config.AzureStorageKey,
+		SASToken:   state.Config.AzureSASToken,
+		AccessTier: state.Config.AzureAccessTier,
+	})
+}
+
+// newRequest - Build a request against the container, or one of its blob when key is not empty.
+func (b *Backend) newRequest(method, key string, query url.Values, body []byte) (*http.Request, error) {
+	u, err := url.Parse(b.Options.Endpoint + "/" + b.Options.Container)
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		u.Path += "/" + b.Options.Prefix + key
+	}
+	if query == nil {
+		query = url.Values{}
+	}
+	for k, v := range b.sas {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		req.Body = nil
+		req.ContentLength = 0
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", apiVersion)
+	return req, nil
+}
+
+// sign - Add the SharedKey authorization header to the request.
+func (b *Backend) sign(req *http.Request) {
+	if b.key == nil {
+		return
+	}
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	var msHeaders []string
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-ms-") {
+			msHeaders = append(msHeaders, lower+":"+strings.TrimSpace(req.Header.Get(name)))
+		}
+	}
+	sort.Strings(msHeaders)
+
+	resource := "/" + b.Options.Account + req.URL.EscapedPath()
+	// Parameters are sorted by their lowercased name, values of the same parameter are merged.
+	query := map[string][]string{}
+	for name, values := range req.URL.Query() {
+		lower := strings.ToLower(name)
+		query[lower] = append(query[lower], values...)
+	}
+	var params []string
+	for name := range query {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	for _, name := range params {
+		values := query[name]
+		sort.Strings(values)
+		resource += "\n" + name + ":" + strings.Join(values, ",")
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		strings.Join(msHeaders, "\n"),
+		resource,
+	}, "\n")
+
+	mac := hmac.New(sha256.New, b.key)
+	mac.Write([]byte(stringToSign))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", b.Options.Account, signature))
+}
+
+// do - Sign and send a request, turning non 2xx responses into errors.
+func (b *Backend) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	b.sign(req)
+	resp, err := b.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, backend.ErrNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("Azure request %s %s failed with status %d: %s", req.Method, req.URL.Path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// Put - Upload body as a block blob.
+func (b *Backend) Put(ctx context.Context, key string, body io.Reader, metadata map[string]string) error {
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	req, err := b.newRequest(http.MethodPut, key, nil, content)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	if b.Options.AccessTier != "" {
+		req.Header.Set("x-ms-access-tier", b.Options.AccessTier)
+	}
+	for k, v := range metadata {
+		req.Header.Set(metadataHeader+k, v)
+	}
+	resp, err := b.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Get - Download a blob content.
+func (b *Backend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := b.newRequest(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// List - List all blobs of the container starting with prefix.
+func (b *Backend) List(ctx context.Context, prefix string) ([]backend.ObjectInfo, error) {
+	var objects []backend.ObjectInfo
+	marker := ""
+	for {
+		query := url.Values{}
+		query.Set("restype", "container")
+		query.Set("comp", "list")
+		query.Set("prefix", b.Options.Prefix+prefix)
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		req, err := b.newRequest(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := b.do(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		var page blobList
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range page.Blobs {
+			lastModified, _ := time.Parse(http.TimeFormat, blob.Properties.LastModified)
+			objects = append(objects, backend.ObjectInfo{
+				Key:          strings.TrimPrefix(blob.Name, b.Options.Prefix),
+				Size:         blob.Properties.ContentLength,
+				LastModified: lastModified,
+			})
+		}
+		if page.NextMarker == "" {
+			return objects, nil
+		}
+		marker = page.NextMarker
+	}
+}
+
+// Delete - Remove a blob.
+func (b *Backend) Delete(ctx context.Context, key string) error {
+	req, err := b.newRequest(http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Stat - Retrieve blob properties and metadata with a HEAD request.
+func (b *Backend) Stat(ctx context.Context, key string) (*backend.ObjectInfo, error) {
+	req, err := b.newRequest(http.MethodHead, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	lastModified, _ := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
+	info := &backend.ObjectInfo{
+		Key:          key,
+		Size:         resp.ContentLength,
+		LastModified: lastModified,
+		Metadata:     make(map[string]string),
+	}
+	for name := range resp.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, metadataHeader) {
+			info.Metadata[strings.TrimPrefix(lower, metadataHeader)] = resp.Header.Get(name)
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,135 @@
+package azure
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// signature - SharedKey signature of stringToSign, computed independently of sign.
+func signature(key []byte, stringToSign string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestSign(t *testing.T) {
+	key := []byte("secret-key")
+	b, err := New(&Options{
+		Account:    "account",
+		Container:  "keys",
+		Prefix:     "backups/",
+		AccountKey: base64.StdEncoding.EncodeToString(key),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const date = "Mon, 04 Jan 2021 10:00:00 GMT"
+
+	tests := []struct {
+		name         string
+		method       string
+		key          string
+		query        url.Values
+		body         []byte
+		headers      map[string]string
+		stringToSign string
+	}{
+		{
+			name:   "list blobs",
+			method: http.MethodGet,
+			query:  url.Values{"restype": {"container"}, "comp": {"list"}, "Prefix": {"backups/kube-system/"}},
+			stringToSign: "GET\n\n\n\n\n\n\n\n\n\n\n\n" +
+				"x-ms-date:" + date + "\nx-ms-version:" + apiVersion + "\n" +
+				"/account/keys\ncomp:list\nprefix:backups/kube-system/\nrestype:container",
+		},
+		{
+			name:   "put blob",
+			method: http.MethodPut,
+			key:    "kube-system/key.yaml",
+			body:   []byte("content"),
+			headers: map[string]string{
+				"Content-Type":        "application/octet-stream",
+				"x-ms-blob-type":      "BlockBlob",
+				"X-Ms-Meta-Secret":    " sealed-secrets-key ",
+				"x-ms-meta-namespace": "kube-system",
+			},
+			stringToSign: "PUT\n\n\n7\n\napplication/octet-stream\n\n\n\n\n\n\n" +
+				"x-ms-blob-type:BlockBlob\nx-ms-date:" + date + "\nx-ms-meta-namespace:kube-system\nx-ms-meta-secret:sealed-secrets-key\nx-ms-version:" + apiVersion + "\n" +
+				"/account/keys/backups/kube-system/key.yaml",
+		},
+		{
+			name:    "conditional delete",
+			method:  http.MethodDelete,
+			key:     "key.yaml",
+			headers: map[string]string{"If-Match": `"0x8D"`},
+			stringToSign: "DELETE\n\n\n\n\n\n\n\n" + `"0x8D"` + "\n\n\n\n" +
+				"x-ms-date:" + date + "\nx-ms-version:" + apiVersion + "\n" +
+				"/account/keys/backups/key.yaml",
+		},
+	}
+	for _, tt := range tests {
+		req, err := b.newRequest(tt.method, tt.key, tt.query, tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("x-ms-date", date)
+		for name, value := range tt.headers {
+			req.Header.Set(name, value)
+		}
+		b.sign(req)
+		want := "SharedKey account:" + signature(key, tt.stringToSign)
+		if got := req.Header.Get("Authorization"); got != want {
+			t.Errorf("%s: Authorization = %s, want %s", tt.name, got, want)
+		}
+	}
+}
+
+func TestSignWithSASToken(t *testing.T) {
+	b, err := New(&Options{Account: "account", Container: "keys", SASToken: "?sv=2019-02-02&sig=abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := b.newRequest(http.MethodGet, "key.yaml", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.sign(req)
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		t.Errorf("Authorization = %s, want none with a SAS token", auth)
+	}
+	if !strings.Contains(req.URL.RawQuery, "sig=abc") {
+		t.Errorf("query %s lacks the SAS token", req.URL.RawQuery)
+	}
+}
+
+func TestNew(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("key"))
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{name: "shared key", opts: Options{Account: "a", Container: "c", AccountKey: key}},
+		{name: "sas token", opts: Options{Account: "a", Container: "c", SASToken: "sig=abc"}},
+		{name: "no credentials", opts: Options{Account: "a", Container: "c"}, wantErr: true},
+		{name: "invalid key", opts: Options{Account: "a", Container: "c", AccountKey: "not base64!"}, wantErr: true},
+		{name: "no container", opts: Options{Account: "a", AccountKey: key}, wantErr: true},
+		{name: "cool tier", opts: Options{Account: "a", Container: "c", AccountKey: key, AccessTier: "Cool"}},
+		{name: "unknown tier", opts: Options{Account: "a", Container: "c", AccountKey: key, AccessTier: "Cold"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		opts := tt.opts
+		b, err := New(&opts)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: New error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if err == nil && b.Client.Timeout != requestTimeout {
+			t.Errorf("%s: client timeout = %s, want %s", tt.name, b.Client.Timeout, requestTimeout)
+		}
+	}
+}
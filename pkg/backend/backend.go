@@ -0,0 +1,103 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+)
+
+// ErrNotFound - Returned by a backend when the requested object does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo - Describe an object stored into a backend.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	Metadata     map[string]string
+}
+
+// Backend - Interface implemented by every storage backend.
+type Backend interface {
+	// Put - Store body under the given key, metadata are attached to the object when the backend supports it.
+	Put(ctx context.Context, key string, body io.Reader, metadata map[string]string) error
+	// Get - Retrieve the content of an object. The caller must close the returned reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// List - List all objects whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Delete - Remove an object.
+	Delete(ctx context.Context, key string) error
+	// Stat - Retrieve information about an object without downloading it.
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+}
+
+// Replicator - Implemented by backends storing several copies of every object.
+type Replicator interface {
+	// Replicate - Copy an existing object to the copies which do not hold it yet, without rewriting the others. It
+	// returns the number of copies written, and fails when fewer copies than required hold the object.
+	Replicate(ctx context.Context, key string, metadata map[string]string) (int, error)
+}
+
+// Mirror - Implemented by backends storing several copies of every object, which may have diverged.
+type Mirror interface {
+	// Copies - Return a backend reading and writing a single copy, for every copy.
+	Copies() []Backend
+}
+
+// Close - Release the resources held by b, such as a local working copy, when it implements io.Closer.
+func Close(b Backend) error {
+	if closer, ok := b.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Factory - Function used to build a backend from the current state.
+type Factory func(state *config.State) (Backend, error)
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register - Make a backend available under the given name. It is meant to be called from the init function of each implementation.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if factory == nil {
+		panic("backend: Register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("backend: Register called twice for backend " + name)
+	}
+	factories[name] = factory
+}
+
+// New - Build the backend registered under the given name.
+func New(name string, state *config.State) (Backend, error) {
+	mu.RLock()
+	factory, ok := factories[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unsupported backend %s (available: %v)", name, Names())
+	}
+	return factory(state)
+}
+
+// Names - List the name of all registered backends.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,75 @@
+package dryrun
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+	log "github.com/sirupsen/logrus"
+)
+
+// Backend - Wrap a backend to log writes and deletions instead of performing them. Reads are forwarded.
+type Backend struct {
+	Backend backend.Backend
+}
+
+// New - Wrap b into a dry-run backend.
+func New(b backend.Backend) *Backend {
+	return &Backend{Backend: b}
+}
+
+// Put - Log the object which would be written.
+func (b *Backend) Put(ctx context.Context, key string, body io.Reader, metadata map[string]string) error {
+	size, err := io.Copy(ioutil.Discard, body)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"filename": key,
+		"size":     size,
+		"metadata": metadata,
+	}).Warning("Dry run: object would be written")
+	return nil
+}
+
+// Get - Forward to the wrapped backend.
+func (b *Backend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return b.Backend.Get(ctx, key)
+}
+
+// List - Forward to the wrapped backend.
+func (b *Backend) List(ctx context.Context, prefix string) ([]backend.ObjectInfo, error) {
+	return b.Backend.List(ctx, prefix)
+}
+
+// Delete - Log the object which would be deleted.
+func (b *Backend) Delete(ctx context.Context, key string) error {
+	log.WithFields(log.Fields{
+		"filename": key,
+	}).Warning("Dry run: object would be deleted")
+	return nil
+}
+
+// Stat - Forward to the wrapped backend.
+func (b *Backend) Stat(ctx context.Context, key string) (*backend.ObjectInfo, error) {
+	return b.Backend.Stat(ctx, key)
+}
+
+// Copies - Wrap every copy of the wrapped backend, which is its only copy unless it is a mirror.
+func (b *Backend) Copies() []backend.Backend {
+	mirror, ok := b.Backend.(backend.Mirror)
+	if !ok {
+		return []backend.Backend{b}
+	}
+	var copies []backend.Backend
+	for _, target := range mirror.Copies() {
+		copies = append(copies, New(target))
+	}
+	return copies
+}
+
+// Close - Close the wrapped backend.
+func (b *Backend) Close() error {
+	return backend.Close(b.Backend)
+}
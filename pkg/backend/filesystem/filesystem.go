@@ -0,0 +1,167 @@
+package filesystem
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+)
+
+const (
+	fileMode = 0600
+	dirMode  = 0700
+)
+
+// Backend - Local filesystem implementation of backend.Backend. It is meant to be used with a mounted PersistentVolume or NFS share.
+type Backend struct {
+	Root string
+}
+
+func init() {
+	backend.Register("filesystem", NewBackend)
+}
+
+// New - Init a filesystem backend rooted at the given directory.
+func New(root string) (*Backend, error) {
+	if root == "" {
+		return nil, fmt.Errorf("No directory has been provided")
+	}
+	err := os.MkdirAll(root, dirMode)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create directory %s: %s", root, err.Error())
+	}
+	return &Backend{Root: root}, nil
+}
+
+// NewBackend - Init the filesystem backend from the current state.
+func NewBackend(state *config.State) (backend.Backend, error) {
+	return New(state.Config.FilesystemPath)
+}
+
+// path - Resolve an object key into a path under the root directory.
+func (b *Backend) path(key string) (string, error) {
+	p := filepath.Join(b.Root, filepath.FromSlash(key))
+	if p != filepath.Clean(b.Root) && !strings.HasPrefix(p, filepath.Clean(b.Root)+string(filepath.Separator)) {
+		return "", fmt.Errorf("Key %s is outside of the backup directory", key)
+	}
+	return p, nil
+}
+
+// Put - Write body into a temporary file and atomically rename it to its final location.
+func (b *Backend) Put(ctx context.Context, key string, body io.Reader, metadata map[string]string) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(p)
+	err = os.MkdirAll(dir, dirMode)
+	if err != nil {
+		return err
+	}
+	tmpfile, err := ioutil.TempFile(dir, "."+filepath.Base(p)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpfile.Name())
+
+	err = tmpfile.Chmod(fileMode)
+	if err == nil {
+		_, err = io.Copy(tmpfile, body)
+	}
+	if err == nil {
+		err = tmpfile.Sync()
+	}
+	if closeErr := tmpfile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	return os.Rename(tmpfile.Name(), p)
+}
+
+// Get - Open the file stored under the given key.
+func (b *Backend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, backend.ErrNotFound
+	}
+	return file, err
+}
+
+// List - Walk the backup directory and return every file whose key starts with prefix.
+func (b *Backend) List(ctx context.Context, prefix string) ([]backend.ObjectInfo, error) {
+	var objects []backend.ObjectInfo
+	err := filepath.Walk(b.Root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(b.Root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		objects = append(objects, backend.ObjectInfo{
+			Key:          key,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// Delete - Remove the file stored under the given key.
+func (b *Backend) Delete(ctx context.Context, key string) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return backend.ErrNotFound
+	}
+	return err
+}
+
+// Stat - Retrieve information about the file stored under the given key.
+func (b *Backend) Stat(ctx context.Context, key string) (*backend.ObjectInfo, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil, backend.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &backend.ObjectInfo{
+		Key:          key,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+	}, nil
+}
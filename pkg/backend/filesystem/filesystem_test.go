@@ -0,0 +1,116 @@
+package filesystem
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+)
+
+func TestBackend(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "filesystem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := New(filepath.Join(dir, "backups"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Stat(ctx, "a.yaml"); err != backend.ErrNotFound {
+		t.Fatalf("Stat of a missing file = %v, want ErrNotFound", err)
+	}
+	if _, err := b.Get(ctx, "a.yaml"); err != backend.ErrNotFound {
+		t.Fatalf("Get of a missing file = %v, want ErrNotFound", err)
+	}
+	for _, key := range []string{"a.yaml", "kube-system/b.yaml", "kube-system/c.yaml"} {
+		if err := b.Put(ctx, key, strings.NewReader("content of "+key), nil); err != nil {
+			t.Fatalf("Put(%s): %v", key, err)
+		}
+	}
+	if err := b.Put(ctx, "a.yaml", strings.NewReader("new content"), nil); err != nil {
+		t.Fatalf("Put over an existing file: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "backups", "kube-system", "b.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != fileMode {
+		t.Errorf("file mode = %o, want %o", mode, fileMode)
+	}
+	reader, err := b.Get(ctx, "a.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil || string(content) != "new content" {
+		t.Errorf("Get = %q, %v", content, err)
+	}
+	object, err := b.Stat(ctx, "kube-system/b.yaml")
+	if err != nil || object.Size != int64(len("content of kube-system/b.yaml")) {
+		t.Errorf("Stat = %+v, %v", object, err)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "", want: []string{"a.yaml", "kube-system/b.yaml", "kube-system/c.yaml"}},
+		{prefix: "kube-system/", want: []string{"kube-system/b.yaml", "kube-system/c.yaml"}},
+		{prefix: "missing/", want: nil},
+	}
+	for _, tt := range tests {
+		objects, err := b.List(ctx, tt.prefix)
+		if err != nil {
+			t.Fatalf("List(%q): %v", tt.prefix, err)
+		}
+		var keys []string
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+		if !reflect.DeepEqual(keys, tt.want) {
+			t.Errorf("List(%q) = %v, want %v", tt.prefix, keys, tt.want)
+		}
+	}
+
+	if err := b.Delete(ctx, "a.yaml"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := b.Delete(ctx, "a.yaml"); err != backend.ErrNotFound {
+		t.Errorf("Delete of a missing file = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPath(t *testing.T) {
+	b := &Backend{Root: "/backups"}
+	tests := []struct {
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{key: "a.yaml", want: "/backups/a.yaml"},
+		{key: "kube-system/a.yaml", want: "/backups/kube-system/a.yaml"},
+		{key: "kube-system/../a.yaml", want: "/backups/a.yaml"},
+		{key: "../a.yaml", wantErr: true},
+		{key: "../backups-other/a.yaml", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := b.path(tt.key)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("path(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+			continue
+		}
+		if got != filepath.FromSlash(tt.want) {
+			t.Errorf("path(%q) = %s, want %s", tt.key, got, tt.want)
+		}
+	}
+}
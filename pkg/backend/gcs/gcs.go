@@ -0,0 +1,343 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+)
+
+const (
+	defaultEndpoint = "https://storage.googleapis.com"
+	storageScope    = "https://www.googleapis.com/auth/devstorage.read_write"
+	defaultTokenURI = "https://oauth2.googleapis.com/token"
+	metadataURL     = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	requestTimeout  = 30 * time.Second
+)
+
+// Options - Settings used to init the GCS backend.
+type Options struct {
+	Bucket string
+	Prefix string
+	// Endpoint - Storage API endpoint, override it to target a fake GCS server.
+	Endpoint string
+	// CredentialsFile - Service account JSON key. When empty the GCE metadata server is used.
+	CredentialsFile string
+	// Anonymous - Do not authenticate requests (local emulators).
+	Anonymous bool
+	// KMSKeyName - Customer managed encryption key used to encrypt objects.
+	KMSKeyName string
+	// Metadata - Custom metadata attached to every object.
+	Metadata map[string]string
+}
+
+// Backend - Google Cloud Storage implementation of backend.Backend using the JSON API.
+type Backend struct {
+	Client  *http.Client
+	Options *Options
+}
+
+type object struct {
+	Name     string            `json:"name"`
+	Size     string            `json:"size,omitempty"`
+	Updated  *time.Time        `json:"updated,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type objectList struct {
+	Items         []object `json:"items"`
+	NextPageToken string   `json:"nextPageToken"`
+}
+
+type serviceAccount struct {
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+}
+
+func init() {
+	backend.Register("gcs", NewBackend)
+}
+
+// New - Init a GCS backend.
+func New(opts *Options) (*Backend, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("No bucket has been provided")
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = defaultEndpoint
+	}
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+
+	// The tokens are fetched with a client bounded by the same timeout as the storage requests.
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Timeout: requestTimeout})
+	client := &http.Client{}
+	switch {
+	case opts.Anonymous:
+	case opts.CredentialsFile != "":
+		content, err := ioutil.ReadFile(opts.CredentialsFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read credentials file %s: %s", opts.CredentialsFile, err.Error())
+		}
+		var sa serviceAccount
+		err = json.Unmarshal(content, &sa)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse credentials file %s: %s", opts.CredentialsFile, err.Error())
+		}
+		if sa.TokenURI == "" {
+			sa.TokenURI = defaultTokenURI
+		}
+		conf := &jwt.Config{
+			Email:        sa.ClientEmail,
+			PrivateKey:   []byte(sa.PrivateKey),
+			PrivateKeyID: sa.PrivateKeyID,
+			Scopes:       []string{storageScope},
+			TokenURL:     sa.TokenURI,
+		}
+		client = conf.Client(ctx)
+	default:
+		client = oauth2.NewClient(ctx, oauth2.ReuseTokenSource(nil, metadataTokenSource{}))
+	}
+	client.Timeout = requestTimeout
+	return &Backend{Client: client, Options: opts}, nil
+}
+
+// NewBackend - Init the GCS backend from the current state.
+func NewBackend(state *config.State) (backend.Backend, error) {
+	return New(&Options{
+		Bucket:          state.Config.GCSBucketName,
+		Prefix:          state.Config.GCSPrefix,
+		Endpoint:        state.Config.GCSEndpoint,
+		CredentialsFile: state.Config.GCSCredentialsFile,
+		Anonymous:       state.Config.GCSAnonymous,
+		KMSKeyName:      state.Config.GCSKMSKeyName,
+		Metadata:        state.Config.GCSMetadata,
+	})
+}
+
+func (b *Backend) objectName(key string) string {
+	return b.Options.Prefix + key
+}
+
+func (b *Backend) objectURL(key string) string {
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", b.Options.Endpoint, url.PathEscape(b.Options.Bucket), url.PathEscape(b.objectName(key)))
+}
+
+// do - Send a request and turn non 2xx responses into errors.
+func (b *Backend) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	resp, err := b.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, backend.ErrNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("GCS request %s %s failed with status %d: %s", req.Method, req.URL.Path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// Put - Upload body with a multipart request holding the object metadata and its content.
+func (b *Backend) Put(ctx context.Context, key string, body io.Reader, metadata map[string]string) error {
+	meta := object{
+		Name:     b.objectName(key),
+		Metadata: make(map[string]string),
+	}
+	for k, v := range b.Options.Metadata {
+		meta.Metadata[k] = v
+	}
+	for k, v := range metadata {
+		meta.Metadata[k] = v
+	}
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	payload := &bytes.Buffer{}
+	writer := multipart.NewWriter(payload)
+	part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(metaBytes)
+	if err != nil {
+		return err
+	}
+	part, err = writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/octet-stream"}})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, body)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("uploadType", "multipart")
+	if b.Options.KMSKeyName != "" {
+		query.Set("kmsKeyName", b.Options.KMSKeyName)
+	}
+	uploadURL := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", b.Options.Endpoint, url.PathEscape(b.Options.Bucket), query.Encode())
+	req, err := http.NewRequest(http.MethodPost, uploadURL, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "multipart/related; boundary="+writer.Boundary())
+	resp, err := b.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Get - Download an object content.
+func (b *Backend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, b.objectURL(key)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// List - List all objects starting with prefix.
+func (b *Backend) List(ctx context.Context, prefix string) ([]backend.ObjectInfo, error) {
+	var objects []backend.ObjectInfo
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("prefix", b.objectName(prefix))
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/storage/v1/b/%s/o?%s", b.Options.Endpoint, url.PathEscape(b.Options.Bucket), query.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := b.do(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		var page objectList
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			objects = append(objects, b.objectInfo(item))
+		}
+		if page.NextPageToken == "" {
+			return objects, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// Delete - Remove an object.
+func (b *Backend) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequest(http.MethodDelete, b.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Stat - Retrieve object metadata.
+func (b *Backend) Stat(ctx context.Context, key string) (*backend.ObjectInfo, error) {
+	req, err := http.NewRequest(http.MethodGet, b.objectURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var obj object
+	err = json.NewDecoder(resp.Body).Decode(&obj)
+	if err != nil {
+		return nil, err
+	}
+	info := b.objectInfo(obj)
+	return &info, nil
+}
+
+// objectInfo - Convert an API object into a backend.ObjectInfo, removing the configured prefix.
+func (b *Backend) objectInfo(obj object) backend.ObjectInfo {
+	size, _ := strconv.ParseInt(obj.Size, 10, 64)
+	info := backend.ObjectInfo{
+		Key:      strings.TrimPrefix(obj.Name, b.Options.Prefix),
+		Size:     size,
+		Metadata: obj.Metadata,
+	}
+	if obj.Updated != nil {
+		info.LastModified = *obj.Updated
+	}
+	return info
+}
+
+// metadataTokenSource - Fetch access tokens from the GCE metadata server (GKE workload identity, node service account).
+type metadataTokenSource struct{}
+
+func (metadataTokenSource) Token() (*oauth2.Token, error) {
+	req, err := http.NewRequest(http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	client := &http.Client{Timeout: requestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get token from metadata server: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to get token from metadata server: status %d", resp.StatusCode)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		TokenType   string `json:"token_type"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{
+		AccessToken: token.AccessToken,
+		TokenType:   token.TokenType,
+		Expiry:      time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+	}, nil
+}
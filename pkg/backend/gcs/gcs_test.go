@@ -0,0 +1,181 @@
+package gcs
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+)
+
+// fakeServer - Minimal in-memory implementation of the GCS JSON API, listing one object per page.
+type fakeServer struct {
+	mu       sync.Mutex
+	objects  map[string]object
+	contents map[string][]byte
+	queries  []url.Values
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	const objects = "/storage/v1/b/bucket/o"
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/upload"+objects:
+		f.queries = append(f.queries, r.URL.Query())
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reader := multipart.NewReader(r.Body, params["boundary"])
+		part, err := reader.NextPart()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var obj object
+		if err := json.NewDecoder(part).Decode(&obj); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		part, err = reader.NextPart()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		content, _ := ioutil.ReadAll(part)
+		updated := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		obj.Size = strconv.Itoa(len(content))
+		obj.Updated = &updated
+		f.objects[obj.Name] = obj
+		f.contents[obj.Name] = content
+		json.NewEncoder(w).Encode(obj)
+	case r.Method == http.MethodGet && r.URL.Path == objects:
+		var names []string
+		for name := range f.objects {
+			if strings.HasPrefix(name, r.URL.Query().Get("prefix")) && name > r.URL.Query().Get("pageToken") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		var page objectList
+		if len(names) > 0 {
+			page.Items = []object{f.objects[names[0]]}
+		}
+		if len(names) > 1 {
+			page.NextPageToken = names[0]
+		}
+		json.NewEncoder(w).Encode(page)
+	case strings.HasPrefix(r.URL.Path, objects+"/"):
+		name := strings.TrimPrefix(r.URL.Path, objects+"/")
+		obj, ok := f.objects[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		switch {
+		case r.Method == http.MethodDelete:
+			delete(f.objects, name)
+			delete(f.contents, name)
+		case r.URL.Query().Get("alt") == "media":
+			w.Write(f.contents[name])
+		default:
+			json.NewEncoder(w).Encode(obj)
+		}
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestBackend(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeServer{objects: map[string]object{}, contents: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	b, err := New(&Options{
+		Bucket:     "bucket",
+		Prefix:     "backups/",
+		Endpoint:   srv.URL + "/",
+		Anonymous:  true,
+		KMSKeyName: "projects/p/locations/l/keyRings/r/cryptoKeys/k",
+		Metadata:   map[string]string{"team": "platform", "secret": "overridden"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Client.Timeout != requestTimeout {
+		t.Errorf("client timeout = %s, want %s", b.Client.Timeout, requestTimeout)
+	}
+
+	if _, err := b.Stat(ctx, "a.yaml"); err != backend.ErrNotFound {
+		t.Fatalf("Stat of a missing object = %v, want ErrNotFound", err)
+	}
+	for _, key := range []string{"a.yaml", "kube-system/b.yaml", "kube-system/c.yaml"} {
+		if err := b.Put(ctx, key, strings.NewReader("content of "+key), map[string]string{"secret": key}); err != nil {
+			t.Fatalf("Put(%s): %v", key, err)
+		}
+	}
+	if got := fake.queries[0].Get("kmsKeyName"); got != b.Options.KMSKeyName {
+		t.Errorf("kmsKeyName = %q, want %q", got, b.Options.KMSKeyName)
+	}
+
+	info, err := b.Stat(ctx, "kube-system/b.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"team": "platform", "secret": "kube-system/b.yaml"}
+	if info.Key != "kube-system/b.yaml" || info.Size != int64(len("content of kube-system/b.yaml")) || !reflect.DeepEqual(info.Metadata, want) {
+		t.Errorf("Stat = %+v", info)
+	}
+	reader, err := b.Get(ctx, "a.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil || string(content) != "content of a.yaml" {
+		t.Errorf("Get = %q, %v", content, err)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "", want: []string{"a.yaml", "kube-system/b.yaml", "kube-system/c.yaml"}},
+		{prefix: "kube-system/", want: []string{"kube-system/b.yaml", "kube-system/c.yaml"}},
+		{prefix: "missing/", want: nil},
+	}
+	for _, tt := range tests {
+		objects, err := b.List(ctx, tt.prefix)
+		if err != nil {
+			t.Fatalf("List(%q): %v", tt.prefix, err)
+		}
+		var keys []string
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+		if !reflect.DeepEqual(keys, tt.want) {
+			t.Errorf("List(%q) = %v, want %v", tt.prefix, keys, tt.want)
+		}
+	}
+
+	if err := b.Delete(ctx, "kube-system/b.yaml"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := b.Delete(ctx, "kube-system/b.yaml"); err != backend.ErrNotFound {
+		t.Errorf("Delete of a missing object = %v, want ErrNotFound", err)
+	}
+}
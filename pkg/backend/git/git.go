@@ -0,0 +1,430 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	pushAttempts = 3
+	// credentialHelper - Answer the credential requests of git with the credentials passed in the environment of the
+	// git processes, so they never appear in the remote URL, the repository configuration or the command line.
+	credentialHelper = `!f() { test "$1" = get || exit 0; echo "username=${KUBESEAL_GIT_USERNAME}"; echo "password=${KUBESEAL_GIT_PASSWORD}"; }; f`
+)
+
+// Options - Settings used to init the git backend.
+type Options struct {
+	// URL - Repository to clone: a local path, an SSH or an HTTPS URL.
+	URL    string
+	Branch string
+	// Path - Directory of the repository where keys are written.
+	Path        string
+	AuthorName  string
+	AuthorEmail string
+	// SSHKeyPath - Private key used for SSH remotes.
+	SSHKeyPath string
+	// KnownHostsPath - known_hosts file used to verify SSH remotes, required by them.
+	KnownHostsPath string
+	// Username and Password - Credentials used for HTTPS remotes.
+	Username string
+	Password string
+}
+
+// Backend - Git implementation of backend.Backend. Every change is committed and pushed to the remote branch.
+type Backend struct {
+	Options *Options
+
+	mu      sync.Mutex
+	workdir string
+	env     []string
+}
+
+func init() {
+	backend.Register("git", NewBackend)
+}
+
+// New - Init a git backend with an empty working copy in a temporary directory, which is removed by Close.
+func New(opts *Options) (*Backend, error) {
+	if opts.URL == "" {
+		return nil, fmt.Errorf("No git repository has been provided")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("git binary not found: %s", err.Error())
+	}
+	if opts.Branch == "" {
+		opts.Branch = "master"
+	}
+
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if opts.Username != "" || opts.Password != "" {
+		env = append(env, "KUBESEAL_GIT_USERNAME="+opts.Username, "KUBESEAL_GIT_PASSWORD="+opts.Password)
+	}
+	if isSSH(opts.URL) || opts.SSHKeyPath != "" {
+		sshCommand, err := sshCommand(opts)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, "GIT_SSH_COMMAND="+sshCommand)
+	}
+
+	workdir, err := ioutil.TempDir("", "kubeseal-git")
+	if err != nil {
+		return nil, err
+	}
+	b := &Backend{
+		Options: opts,
+		workdir: workdir,
+		env:     env,
+	}
+	ctx := context.Background()
+	commands := [][]string{
+		{"init", "--quiet"},
+		{"remote", "add", "origin", opts.URL},
+		{"symbolic-ref", "HEAD", "refs/heads/" + opts.Branch},
+	}
+	if opts.Username != "" || opts.Password != "" {
+		commands = append(commands, []string{"config", "credential.helper", credentialHelper})
+	}
+	for _, args := range commands {
+		if _, err := b.git(ctx, args...); err != nil {
+			os.RemoveAll(workdir)
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// isSSH - Tell whether a repository URL is reached over SSH: an ssh:// URL or the scp-like user@host:path syntax.
+func isSSH(url string) bool {
+	if strings.HasPrefix(url, "ssh://") || strings.HasPrefix(url, "git+ssh://") {
+		return true
+	}
+	if strings.Contains(url, "://") {
+		return false
+	}
+	// As git, a colon before any slash denotes a host, except for local paths.
+	colon := strings.Index(url, ":")
+	slash := strings.Index(url, "/")
+	return colon > 0 && (slash < 0 || colon < slash)
+}
+
+// sshCommand - Build the ssh command run by git, which only trusts the hosts of the known_hosts file.
+func sshCommand(opts *Options) (string, error) {
+	if opts.KnownHostsPath == "" {
+		return "", fmt.Errorf("GIT_KNOWN_HOSTS_PATH is required to verify the SSH remote %s", opts.URL)
+	}
+	command := "ssh"
+	if opts.SSHKeyPath != "" {
+		command += " -i " + shellQuote(opts.SSHKeyPath) + " -o IdentitiesOnly=yes"
+	}
+	command += " -o UserKnownHostsFile=" + shellQuote(opts.KnownHostsPath) + " -o StrictHostKeyChecking=yes"
+	return command, nil
+}
+
+// shellQuote - Quote a word for the shell interpreting GIT_SSH_COMMAND.
+func shellQuote(word string) string {
+	return "'" + strings.Replace(word, "'", `'\''`, -1) + "'"
+}
+
+// NewBackend - Init the git backend from the current state. Keys are only committed in clear text when
+// GIT_ALLOW_PLAINTEXT is set, as everyone able to read the repository or one of its clones could read them.
+func NewBackend(state *config.State) (backend.Backend, error) {
+	if state.Config.Encryption == "" && !state.Config.GitAllowPlaintext {
+		return nil, fmt.Errorf("The git backend requires ENCRYPTION, set GIT_ALLOW_PLAINTEXT=true to commit the keys in clear text")
+	}
+	return New(&Options{
+		URL:            state.Config.GitURL,
+		Branch:         state.Config.GitBranch,
+		Path:           state.Config.GitPath,
+		AuthorName:     state.Config.GitAuthorName,
+		AuthorEmail:    state.Config.GitAuthorEmail,
+		SSHKeyPath:     state.Config.GitSSHKeyPath,
+		KnownHostsPath: state.Config.GitKnownHostsPath,
+		Username:       state.Config.GitUsername,
+		Password:       state.Config.GitPassword,
+	})
+}
+
+// Close - Remove the working copy.
+func (b *Backend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return os.RemoveAll(b.workdir)
+}
+
+// git - Run a git command inside the working copy.
+func (b *Backend) git(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = b.workdir
+	cmd.Env = b.env
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if b.Options.Password != "" {
+			msg = strings.Replace(msg, b.Options.Password, "***", -1)
+		}
+		return "", fmt.Errorf("git %s failed: %s: %s", args[0], err.Error(), msg)
+	}
+	return stdout.String(), nil
+}
+
+// sync - Reset the working copy on the remote branch. The branch is created on the first push when it does not exist yet.
+func (b *Backend) sync(ctx context.Context) error {
+	_, err := b.git(ctx, "fetch", "--quiet", "origin")
+	if err != nil {
+		return err
+	}
+	_, err = b.git(ctx, "rev-parse", "--verify", "--quiet", "refs/remotes/origin/"+b.Options.Branch)
+	if err != nil {
+		return nil
+	}
+	_, err = b.git(ctx, "reset", "--quiet", "--hard", "origin/"+b.Options.Branch)
+	if err != nil {
+		return err
+	}
+	_, err = b.git(ctx, "clean", "--quiet", "-fd")
+	return err
+}
+
+// path - Resolve an object key into a path of the working copy.
+func (b *Backend) path(key string) (string, error) {
+	root := filepath.Join(b.workdir, filepath.FromSlash(b.Options.Path))
+	p := filepath.Join(root, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("Key %s is outside of the repository path", key)
+	}
+	return p, nil
+}
+
+// commit - Commit staged changes and push them. Returns false when there was nothing to commit.
+func (b *Backend) commit(ctx context.Context, message string) (bool, error) {
+	_, err := b.git(ctx, "diff", "--cached", "--quiet")
+	if err == nil {
+		return false, nil
+	}
+	_, err = b.git(ctx,
+		"-c", "user.name="+b.Options.AuthorName,
+		"-c", "user.email="+b.Options.AuthorEmail,
+		"commit", "--quiet", "-m", message)
+	if err != nil {
+		return false, err
+	}
+	_, err = b.git(ctx, "push", "--quiet", "origin", "HEAD:refs/heads/"+b.Options.Branch)
+	return true, err
+}
+
+// change - Apply a change on top of the remote branch, commit and push it. The whole operation is retried when the
+// push is rejected because the remote branch moved in the meantime.
+func (b *Backend) change(ctx context.Context, message string, apply func() error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var err error
+	for attempt := 1; attempt <= pushAttempts; attempt++ {
+		err = b.sync(ctx)
+		if err != nil {
+			return err
+		}
+		err = apply()
+		if err != nil {
+			return err
+		}
+		var pushed bool
+		pushed, err = b.commit(ctx, message)
+		if err == nil {
+			if !pushed {
+				log.WithFields(log.Fields{
+					"branch": b.Options.Branch,
+				}).Info("Nothing to commit, repository is already up to date")
+			}
+			return nil
+		}
+		log.WithFields(log.Fields{
+			"error":   err.Error(),
+			"attempt": attempt,
+		}).Warning("Unable to push to git repository")
+	}
+	return err
+}
+
+// Put - Write body into the repository then commit and push it.
+func (b *Backend) Put(ctx context.Context, key string, body io.Reader, metadata map[string]string) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	return b.change(ctx, commitMessage(key, metadata), func() error {
+		err := os.MkdirAll(filepath.Dir(p), 0700)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(p, content, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = b.git(ctx, "add", "--", p)
+		return err
+	})
+}
+
+// Get - Read a file from the remote branch.
+func (b *Backend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err = b.sync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, backend.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+// List - List every file of the repository path whose key starts with prefix.
+func (b *Backend) List(ctx context.Context, prefix string) ([]backend.ObjectInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.sync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	root := filepath.Join(b.workdir, filepath.FromSlash(b.Options.Path))
+	var objects []backend.ObjectInfo
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		object, err := b.stat(ctx, key, info)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, *object)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// Delete - Remove a file from the repository then commit and push it.
+func (b *Backend) Delete(ctx context.Context, key string) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	return b.change(ctx, fmt.Sprintf("Remove sealed-secrets key backup %s", key), func() error {
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			return backend.ErrNotFound
+		}
+		_, err := b.git(ctx, "rm", "--quiet", "--", p)
+		return err
+	})
+}
+
+// Stat - Retrieve information about a file, its modification date is the date of the last commit touching it.
+func (b *Backend) Stat(ctx context.Context, key string) (*backend.ObjectInfo, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err = b.sync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil, backend.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.stat(ctx, key, info)
+}
+
+func (b *Backend) stat(ctx context.Context, key string, info os.FileInfo) (*backend.ObjectInfo, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	out, err := b.git(ctx, "log", "-1", "--format=%cI", "--", p)
+	if err != nil {
+		return nil, err
+	}
+	lastModified, err := time.Parse(time.RFC3339, strings.TrimSpace(out))
+	if err != nil {
+		lastModified = info.ModTime()
+	}
+	return &backend.ObjectInfo{
+		Key:          key,
+		Size:         info.Size(),
+		LastModified: lastModified,
+	}, nil
+}
+
+// commitMessage - Describe a backup with the metadata sent by the caller.
+func commitMessage(key string, metadata map[string]string) string {
+	message := fmt.Sprintf("Backup sealed-secrets key %s\n", key)
+	if len(metadata) == 0 {
+		return message
+	}
+	names := make([]string, 0, len(metadata))
+	for name := range metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	message += "\n"
+	for _, name := range names {
+		message += fmt.Sprintf("%s: %s\n", strings.Title(name), metadata[name])
+	}
+	return message
+}
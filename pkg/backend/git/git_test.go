@@ -0,0 +1,246 @@
+package git
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+)
+
+// bareRepository - Create a bare repository in a temporary directory.
+func bareRepository(t *testing.T) (string, func()) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not found")
+	}
+	dir, err := ioutil.TempDir("", "git-backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := filepath.Join(dir, "remote.git")
+	out, err := exec.Command("git", "init", "--quiet", "--bare", remote).CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("git init: %s: %s", err, out)
+	}
+	return remote, func() { os.RemoveAll(dir) }
+}
+
+func newBackend(t *testing.T, opts *Options) *Backend {
+	if opts.AuthorName == "" {
+		opts.AuthorName, opts.AuthorEmail = "test", "test@localhost"
+	}
+	b, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBackend(t *testing.T) {
+	ctx := context.Background()
+	remote, cleanup := bareRepository(t)
+	defer cleanup()
+	b := newBackend(t, &Options{URL: remote, Path: "keys"})
+	defer b.Close()
+
+	if _, err := b.Stat(ctx, "a.yaml"); err != backend.ErrNotFound {
+		t.Fatalf("Stat on an empty repository = %v, want ErrNotFound", err)
+	}
+	for _, key := range []string{"a.yaml", "dir/b.yaml"} {
+		if err := b.Put(ctx, key, strings.NewReader("content of "+key), map[string]string{"fingerprint": "abc"}); err != nil {
+			t.Fatalf("Put(%s): %v", key, err)
+		}
+	}
+
+	// A second working copy sees the pushed commits.
+	other := newBackend(t, &Options{URL: remote, Path: "keys"})
+	defer other.Close()
+	reader, err := other.Get(ctx, "dir/b.yaml")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	content, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil || string(content) != "content of dir/b.yaml" {
+		t.Fatalf("Get = %q, %v", content, err)
+	}
+	info, err := other.Stat(ctx, "a.yaml")
+	if err != nil || info.Size != int64(len("content of a.yaml")) || info.LastModified.IsZero() {
+		t.Fatalf("Stat = %+v, %v", info, err)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "", want: []string{"a.yaml", "dir/b.yaml"}},
+		{prefix: "dir/", want: []string{"dir/b.yaml"}},
+		{prefix: "missing", want: nil},
+	}
+	for _, tt := range tests {
+		objects, err := other.List(ctx, tt.prefix)
+		if err != nil {
+			t.Fatalf("List(%q): %v", tt.prefix, err)
+		}
+		var keys []string
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+		if strings.Join(keys, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("List(%q) = %v, want %v", tt.prefix, keys, tt.want)
+		}
+	}
+
+	if err := other.Delete(ctx, "a.yaml"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := b.Delete(ctx, "a.yaml"); err != backend.ErrNotFound {
+		t.Fatalf("Delete of a removed object = %v, want ErrNotFound", err)
+	}
+	if err := b.Put(ctx, "../escape.yaml", strings.NewReader(""), nil); err == nil {
+		t.Error("Put outside of the repository path succeeded")
+	}
+}
+
+func TestClose(t *testing.T) {
+	remote, cleanup := bareRepository(t)
+	defer cleanup()
+	b := newBackend(t, &Options{URL: remote})
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(b.workdir); !os.IsNotExist(err) {
+		t.Errorf("working copy %s still exists after Close", b.workdir)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not found")
+	}
+	b := newBackend(t, &Options{URL: "https://git.example.com/keys.git", Username: "bot", Password: "s3cr3t"})
+	defer b.Close()
+	ctx := context.Background()
+
+	url, err := b.git(ctx, "remote", "get-url", "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(url) != "https://git.example.com/keys.git" {
+		t.Errorf("remote URL = %s, want it without credentials", url)
+	}
+	gitConfig, err := ioutil.ReadFile(filepath.Join(b.workdir, ".git", "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(gitConfig), "s3cr3t") {
+		t.Error("password written into the repository configuration")
+	}
+
+	cmd := exec.Command("git", "credential", "fill")
+	cmd.Dir = b.workdir
+	cmd.Env = b.env
+	cmd.Stdin = strings.NewReader("protocol=https\nhost=git.example.com\n\n")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git credential fill: %v", err)
+	}
+	for _, line := range []string{"username=bot", "password=s3cr3t"} {
+		if !strings.Contains(string(out), line) {
+			t.Errorf("credential helper output %q lacks %s", out, line)
+		}
+	}
+}
+
+func TestNewBackendRequiresEncryption(t *testing.T) {
+	remote, cleanup := bareRepository(t)
+	defer cleanup()
+	tests := []struct {
+		encryption string
+		plaintext  bool
+		wantErr    bool
+	}{
+		{encryption: "", wantErr: true},
+		{encryption: "", plaintext: true},
+		{encryption: "age"},
+	}
+	for _, tt := range tests {
+		b, err := NewBackend(&config.State{Config: &config.Config{
+			GitURL:            remote,
+			GitBranch:         "master",
+			Encryption:        tt.encryption,
+			GitAllowPlaintext: tt.plaintext,
+		}})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewBackend(encryption=%q, plaintext=%v) error = %v, wantErr %v", tt.encryption, tt.plaintext, err, tt.wantErr)
+		}
+		if err == nil {
+			backend.Close(b)
+		}
+	}
+}
+
+func TestIsSSH(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{url: "git@github.com:org/keys.git", want: true},
+		{url: "github.com:keys.git", want: true},
+		{url: "ssh://git@github.com/org/keys.git", want: true},
+		{url: "git+ssh://git@github.com/org/keys.git", want: true},
+		{url: "https://github.com/org/keys.git"},
+		{url: "file:///srv/keys.git"},
+		{url: "/srv/keys.git"},
+		{url: "./repositories/a:b.git"},
+	}
+	for _, tt := range tests {
+		if got := isSSH(tt.url); got != tt.want {
+			t.Errorf("isSSH(%s) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestSSHCommand(t *testing.T) {
+	if _, err := New(&Options{URL: "git@github.com:org/keys.git", SSHKeyPath: "/keys/id_ed25519"}); err == nil {
+		t.Fatal("New accepted an SSH remote without known_hosts file")
+	}
+
+	// A fake ssh prints the arguments it receives from the shell running GIT_SSH_COMMAND.
+	dir, err := ioutil.TempDir("", "git-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "ssh"), []byte("#!/bin/sh\nprintf '%s\\n' \"$@\"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{
+		URL:            "git@github.com:org/keys.git",
+		SSHKeyPath:     "/keys/it's a key; rm -rf /",
+		KnownHostsPath: "/keys/known hosts",
+	}
+	command, err := sshCommand(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), "PATH="+dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s: %v", command, err)
+	}
+	want := []string{
+		"-i", opts.SSHKeyPath, "-o", "IdentitiesOnly=yes",
+		"-o", "UserKnownHostsFile=" + opts.KnownHostsPath, "-o", "StrictHostKeyChecking=yes",
+	}
+	if got := strings.Split(strings.TrimSpace(string(out)), "\n"); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("ssh arguments = %q, want %q", got, want)
+	}
+}
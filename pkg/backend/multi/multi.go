@@ -0,0 +1,319 @@
+package multi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+	log "github.com/sirupsen/logrus"
+)
+
+// Target - A named backend receiving the backups.
+type Target struct {
+	Name    string
+	Backend backend.Backend
+}
+
+// Backend - Fan out every write to several backends and decide whether it succeeded with a policy.
+type Backend struct {
+	Targets []Target
+	// Required - Number of targets which must accept a write for it to succeed.
+	Required int
+}
+
+// Unavailable - Backend returning Err on every call. It stands for a target which could not be initialized, so
+// that its failure is accounted by the policy instead of aborting the whole run.
+type Unavailable struct {
+	Err error
+}
+
+// ParsePolicy - Convert a policy (all, any or quorum:N) into the number of targets required for a write to succeed.
+func ParsePolicy(policy string, targets int) (int, error) {
+	switch {
+	case policy == "all":
+		return targets, nil
+	case policy == "any":
+		return 1, nil
+	case strings.HasPrefix(policy, "quorum:"):
+		n, err := strconv.Atoi(strings.TrimPrefix(policy, "quorum:"))
+		if err != nil || n < 1 || n > targets {
+			return 0, fmt.Errorf("Invalid policy %s: quorum must be between 1 and %d", policy, targets)
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("Unsupported policy %s (available: all, any, quorum:N)", policy)
+	}
+}
+
+// New - Init a backend writing to all targets.
+func New(targets []Target, policy string) (*Backend, error) {
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("No backend has been provided")
+	}
+	required, err := ParsePolicy(policy, len(targets))
+	if err != nil {
+		return nil, err
+	}
+	return &Backend{Targets: targets, Required: required}, nil
+}
+
+// each - Run fn concurrently on every target and return the errors indexed like Targets.
+func (b *Backend) each(fn func(i int, target Target) error) []error {
+	errs := make([]error, len(b.Targets))
+	var wg sync.WaitGroup
+	for i, target := range b.Targets {
+		wg.Add(1)
+		go func(i int, target Target) {
+			defer wg.Done()
+			errs[i] = fn(i, target)
+		}(i, target)
+	}
+	wg.Wait()
+	return errs
+}
+
+// Put - Upload body to every target concurrently. It succeeds when at least Required targets accepted it.
+func (b *Backend) Put(ctx context.Context, key string, body io.Reader, metadata map[string]string) error {
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	errs := b.each(func(i int, target Target) error {
+		return target.Backend.Put(ctx, key, bytes.NewReader(content), metadata)
+	})
+
+	succeeded := 0
+	var failures []string
+	for i, err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		log.WithFields(log.Fields{
+			"error":   err.Error(),
+			"backend": b.Targets[i].Name,
+			"key":     key,
+		}).Warning("Unable to upload object to backend")
+		failures = append(failures, fmt.Sprintf("%s: %s", b.Targets[i].Name, err.Error()))
+	}
+	if succeeded < b.Required {
+		return fmt.Errorf("Object uploaded to %d/%d backends, %d required (%s)", succeeded, len(b.Targets), b.Required, strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// Get - Download an object from the first target holding it.
+func (b *Backend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	var lastErr error = backend.ErrNotFound
+	for _, target := range b.Targets {
+		body, err := target.Backend.Get(ctx, key)
+		if err == nil {
+			return body, nil
+		}
+		if err != backend.ErrNotFound {
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
+
+// List - Merge the objects listed by every target.
+func (b *Backend) List(ctx context.Context, prefix string) ([]backend.ObjectInfo, error) {
+	results := make([][]backend.ObjectInfo, len(b.Targets))
+	errs := b.each(func(i int, target Target) error {
+		var err error
+		results[i], err = target.Backend.List(ctx, prefix)
+		return err
+	})
+
+	seen := make(map[string]bool)
+	var objects []backend.ObjectInfo
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			failed++
+			log.WithFields(log.Fields{
+				"error":   err.Error(),
+				"backend": b.Targets[i].Name,
+			}).Warning("Unable to list objects from backend")
+			continue
+		}
+		for _, object := range results[i] {
+			if !seen[object.Key] {
+				seen[object.Key] = true
+				objects = append(objects, object)
+			}
+		}
+	}
+	if failed == len(b.Targets) {
+		return nil, errs[0]
+	}
+	return objects, nil
+}
+
+// Delete - Remove an object from every target.
+func (b *Backend) Delete(ctx context.Context, key string) error {
+	errs := b.each(func(i int, target Target) error {
+		return target.Backend.Delete(ctx, key)
+	})
+	notFound := 0
+	var failures []string
+	for i, err := range errs {
+		switch err {
+		case nil:
+		case backend.ErrNotFound:
+			notFound++
+		default:
+			failures = append(failures, fmt.Sprintf("%s: %s", b.Targets[i].Name, err.Error()))
+		}
+	}
+	if notFound == len(b.Targets) {
+		return backend.ErrNotFound
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("Unable to delete object from all backends (%s)", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// stat - Stat the object on every target.
+func (b *Backend) stat(ctx context.Context, key string) ([]*backend.ObjectInfo, []error) {
+	infos := make([]*backend.ObjectInfo, len(b.Targets))
+	errs := b.each(func(i int, target Target) error {
+		var err error
+		infos[i], err = target.Backend.Stat(ctx, key)
+		return err
+	})
+	return infos, errs
+}
+
+// Stat - Retrieve object information from the first target holding it. ErrNotFound is only returned when no target
+// holds the object, missing copies are written by Replicate.
+func (b *Backend) Stat(ctx context.Context, key string) (*backend.ObjectInfo, error) {
+	infos, errs := b.stat(ctx, key)
+	for i, err := range errs {
+		if err == nil {
+			return infos[i], nil
+		}
+	}
+	for i, err := range errs {
+		if err != backend.ErrNotFound {
+			return nil, fmt.Errorf("%s: %s", b.Targets[i].Name, err.Error())
+		}
+	}
+	return nil, backend.ErrNotFound
+}
+
+// Replicate - Copy an object to the targets which report it as not found, targets holding it are never rewritten.
+// It fails when fewer than Required targets hold the object afterwards, such as when a target cannot be checked.
+func (b *Backend) Replicate(ctx context.Context, key string, metadata map[string]string) (int, error) {
+	_, errs := b.stat(ctx, key)
+	source := -1
+	held := 0
+	var missing []int
+	var failures []string
+	for i, err := range errs {
+		switch err {
+		case nil:
+			held++
+			if source < 0 {
+				source = i
+			}
+		case backend.ErrNotFound:
+			missing = append(missing, i)
+		default:
+			failures = append(failures, fmt.Sprintf("%s: %s", b.Targets[i].Name, err.Error()))
+		}
+	}
+	if source < 0 {
+		if len(failures) > 0 {
+			return 0, fmt.Errorf("Unable to check object on backends (%s)", strings.Join(failures, "; "))
+		}
+		return 0, backend.ErrNotFound
+	}
+
+	written := 0
+	if len(missing) > 0 {
+		reader, err := b.Targets[source].Backend.Get(ctx, key)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %s", b.Targets[source].Name, err.Error())
+		}
+		content, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return 0, fmt.Errorf("%s: %s", b.Targets[source].Name, err.Error())
+		}
+		for _, i := range missing {
+			err := b.Targets[i].Backend.Put(ctx, key, bytes.NewReader(content), metadata)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %s", b.Targets[i].Name, err.Error()))
+				continue
+			}
+			written++
+		}
+	}
+	for _, failure := range failures {
+		log.WithFields(log.Fields{
+			"error": failure,
+			"key":   key,
+		}).Warning("Unable to copy object to backend")
+	}
+	if held+written < b.Required {
+		return written, fmt.Errorf("Object stored on %d/%d backends, %d required (%s)", held+written, len(b.Targets), b.Required, strings.Join(failures, "; "))
+	}
+	return written, nil
+}
+
+// Copies - Return every target, as the objects they hold, such as the catalog, may have diverged.
+func (b *Backend) Copies() []backend.Backend {
+	copies := make([]backend.Backend, 0, len(b.Targets))
+	for _, target := range b.Targets {
+		copies = append(copies, target.Backend)
+	}
+	return copies
+}
+
+// Close - Close every target.
+func (b *Backend) Close() error {
+	var failures []string
+	for _, target := range b.Targets {
+		if err := backend.Close(target.Backend); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", target.Name, err.Error()))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("Unable to close backends (%s)", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// Put - Always fail with the initialization error.
+func (u *Unavailable) Put(ctx context.Context, key string, body io.Reader, metadata map[string]string) error {
+	return u.Err
+}
+
+// Get - Always fail with the initialization error.
+func (u *Unavailable) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return nil, u.Err
+}
+
+// List - Always fail with the initialization error.
+func (u *Unavailable) List(ctx context.Context, prefix string) ([]backend.ObjectInfo, error) {
+	return nil, u.Err
+}
+
+// Delete - Always fail with the initialization error.
+func (u *Unavailable) Delete(ctx context.Context, key string) error {
+	return u.Err
+}
+
+// Stat - Always fail with the initialization error.
+func (u *Unavailable) Stat(ctx context.Context, key string) (*backend.ObjectInfo, error) {
+	return nil, u.Err
+}
@@ -0,0 +1,212 @@
+package multi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend/filesystem"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		policy   string
+		targets  int
+		required int
+		wantErr  bool
+	}{
+		{policy: "all", targets: 3, required: 3},
+		{policy: "any", targets: 3, required: 1},
+		{policy: "quorum:2", targets: 3, required: 2},
+		{policy: "quorum:3", targets: 3, required: 3},
+		{policy: "quorum:0", targets: 3, wantErr: true},
+		{policy: "quorum:4", targets: 3, wantErr: true},
+		{policy: "quorum:two", targets: 3, wantErr: true},
+		{policy: "most", targets: 3, wantErr: true},
+		{policy: "", targets: 3, wantErr: true},
+	}
+	for _, tt := range tests {
+		required, err := ParsePolicy(tt.policy, tt.targets)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePolicy(%q, %d) error = %v, wantErr %v", tt.policy, tt.targets, err, tt.wantErr)
+			continue
+		}
+		if required != tt.required {
+			t.Errorf("ParsePolicy(%q, %d) = %d, want %d", tt.policy, tt.targets, required, tt.required)
+		}
+	}
+}
+
+// newTargets - Filesystem targets rooted in temporary directories.
+func newTargets(t *testing.T, n int) ([]Target, func()) {
+	var targets []Target
+	var dirs []string
+	for i := 0; i < n; i++ {
+		dir, err := ioutil.TempDir("", "multi")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, dir)
+		fs, err := filesystem.New(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets = append(targets, Target{Name: dir, Backend: fs})
+	}
+	return targets, func() {
+		for _, dir := range dirs {
+			os.RemoveAll(dir)
+		}
+	}
+}
+
+func read(t *testing.T, b backend.Backend, key string) string {
+	reader, err := b.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+	defer reader.Close()
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestPutPolicy(t *testing.T) {
+	ctx := context.Background()
+	targets, cleanup := newTargets(t, 2)
+	defer cleanup()
+	targets = append(targets, Target{Name: "down", Backend: &Unavailable{Err: errors.New("down")}})
+
+	tests := []struct {
+		policy  string
+		wantErr bool
+	}{
+		{policy: "all", wantErr: true},
+		{policy: "quorum:2"},
+		{policy: "any"},
+	}
+	for _, tt := range tests {
+		b, err := New(targets, tt.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = b.Put(ctx, "key", strings.NewReader("content"), nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Put with policy %s error = %v, wantErr %v", tt.policy, err, tt.wantErr)
+		}
+	}
+}
+
+func TestStat(t *testing.T) {
+	ctx := context.Background()
+	targets, cleanup := newTargets(t, 2)
+	defer cleanup()
+	b, err := New(targets, "all")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Stat(ctx, "key"); err != backend.ErrNotFound {
+		t.Fatalf("Stat of a missing object = %v, want ErrNotFound", err)
+	}
+	if err := targets[1].Backend.Put(ctx, "key", strings.NewReader("content"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Stat(ctx, "key"); err != nil {
+		t.Fatalf("Stat of an object held by one target = %v, want nil", err)
+	}
+
+	withDown := append(targets[:1:1], Target{Name: "down", Backend: &Unavailable{Err: errors.New("down")}})
+	b, err = New(withDown, "any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Stat(ctx, "key"); err == nil || err == backend.ErrNotFound {
+		t.Fatalf("Stat with an unavailable target and no copy = %v, want an error", err)
+	}
+}
+
+func TestReplicate(t *testing.T) {
+	ctx := context.Background()
+	targets, cleanup := newTargets(t, 3)
+	defer cleanup()
+	targets = append(targets, Target{Name: "down", Backend: &Unavailable{Err: errors.New("down")}})
+	b, err := New(targets, "any")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first copy is kept, the second one must not be rewritten, the third one is missing.
+	for i, content := range []string{"original", "other"} {
+		if err := targets[i].Backend.Put(ctx, "key", bytes.NewReader([]byte(content)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	written, err := b.Replicate(ctx, "key", nil)
+	if err != nil {
+		t.Fatalf("Replicate: %v", err)
+	}
+	if written != 1 {
+		t.Errorf("Replicate wrote %d copies, want 1", written)
+	}
+	if got := read(t, targets[1].Backend, "key"); got != "other" {
+		t.Errorf("existing copy has been rewritten: %q", got)
+	}
+	if got := read(t, targets[2].Backend, "key"); got != "original" {
+		t.Errorf("missing copy = %q, want %q", got, "original")
+	}
+
+	written, err = b.Replicate(ctx, "key", nil)
+	if err != nil || written != 0 {
+		t.Errorf("second Replicate = %d, %v, want 0, nil", written, err)
+	}
+	if _, err := b.Replicate(ctx, "missing", nil); err == nil || err == backend.ErrNotFound {
+		t.Errorf("Replicate of a missing object with an unavailable target = %v, want an error", err)
+	}
+	b.Targets = targets[:3]
+	if _, err := b.Replicate(ctx, "missing", nil); err != backend.ErrNotFound {
+		t.Errorf("Replicate of a missing object = %v, want ErrNotFound", err)
+	}
+}
+
+func TestReplicatePolicy(t *testing.T) {
+	ctx := context.Background()
+	targets, cleanup := newTargets(t, 2)
+	defer cleanup()
+	targets = append(targets, Target{Name: "down", Backend: &Unavailable{Err: errors.New("down")}})
+
+	tests := []struct {
+		policy  string
+		wantErr bool
+	}{
+		{policy: "all", wantErr: true},
+		{policy: "quorum:2"},
+		{policy: "any"},
+	}
+	for _, tt := range tests {
+		b, err := New(targets, tt.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := targets[0].Backend.Put(ctx, "key", strings.NewReader("content"), nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := targets[1].Backend.Delete(ctx, "key"); err != nil && err != backend.ErrNotFound {
+			t.Fatal(err)
+		}
+		written, err := b.Replicate(ctx, "key", nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Replicate with policy %s error = %v, wantErr %v", tt.policy, err, tt.wantErr)
+		}
+		if written != 1 {
+			t.Errorf("Replicate with policy %s wrote %d copies, want 1", tt.policy, written)
+		}
+	}
+}
@@ -1,28 +1,329 @@
 package s3
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
 )
 
+// taggedMetadata - Metadata entries also applied as object tags.
+var taggedMetadata = []string{"cluster", "controller", "fingerprint"}
+
+// Backend - S3 implementation of backend.Backend
+type Backend struct {
+	Session *session.Session
+	Client  *s3.S3
+	Bucket  string
+	Upload  UploadOptions
+}
+
+// UploadOptions - Server-side settings applied to uploaded objects.
+type UploadOptions struct {
+	// SSE - Server-side encryption: AES256 (SSE-S3), aws:kms (SSE-KMS) or customer (SSE-C).
+	SSE      string
+	KMSKeyID string
+	// CustomerKey - 256 bits key used with SSE-C, it is required to read the object back.
+	CustomerKey  []byte
+	StorageClass string
+	Tags         map[string]string
+	// ObjectLockMode - GOVERNANCE or COMPLIANCE, requires a bucket with Object Lock enabled.
+	ObjectLockMode        string
+	ObjectLockRetention   time.Duration
+	ObjectLockRetainUntil time.Time
+}
+
+func init() {
+	backend.Register("s3", NewBackend)
+}
+
+// Options - Settings used to open an AWS session.
+type Options struct {
+	Region string
+	// Endpoint - Custom S3 compatible endpoint URL (MinIO, Ceph RGW...).
+	Endpoint       string
+	ForcePathStyle bool
+	DisableSSL     bool
+	// CABundle - Path to a PEM file containing the CA used by the endpoint.
+	CABundle string
+}
+
 // New - Init AWS client
-func New(region string) (*session.Session, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(region)},
-	)
+func New(opts *Options) (*session.Session, error) {
+	awsConfig := &aws.Config{
+		Region:           aws.String(opts.Region),
+		S3ForcePathStyle: aws.Bool(opts.ForcePathStyle),
+		DisableSSL:       aws.Bool(opts.DisableSSL),
+	}
+	if opts.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(opts.Endpoint)
+	}
+	sessOpts := session.Options{
+		Config: *awsConfig,
+	}
+	if opts.CABundle != "" {
+		bundle, err := os.Open(opts.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open CA bundle %s: %s", opts.CABundle, err.Error())
+		}
+		defer bundle.Close()
+		sessOpts.CustomCABundle = bundle
+	}
+	sess, err := session.NewSessionWithOptions(sessOpts)
 	if err != nil {
 		return nil, err
 	}
 	return sess, nil
 }
 
-// PutObject - To upload object into an s3 bucket
-func PutObject(session *session.Session, input *s3manager.UploadInput) error {
-	uploader := s3manager.NewUploader(session)
-	_, err := uploader.Upload(input)
+// NewBackend - Init the S3 backend from the current state.
+func NewBackend(state *config.State) (backend.Backend, error) {
+	if state.Config.AWSRegion == "" {
+		return nil, fmt.Errorf("AWS_REGION is required by the s3 backend")
+	}
+	if state.Config.AWSBucketName == "" {
+		return nil, fmt.Errorf("AWS_BUCKET_NAME is required by the s3 backend")
+	}
+	sess, err := New(&Options{
+		Region:         state.Config.AWSRegion,
+		Endpoint:       state.Config.AWSEndpoint,
+		ForcePathStyle: state.Config.AWSS3ForcePathStyle,
+		DisableSSL:     state.Config.AWSDisableSSL,
+		CABundle:       state.Config.AWSCABundle,
+	})
+	if err != nil {
+		return nil, err
+	}
+	upload, err := NewUploadOptions(state.Config)
+	if err != nil {
+		return nil, err
+	}
+	state.AWSClient = sess
+	return &Backend{
+		Session: sess,
+		Client:  s3.New(sess),
+		Bucket:  state.Config.AWSBucketName,
+		Upload:  *upload,
+	}, nil
+}
+
+// NewUploadOptions - Validate and load the upload settings from the configuration.
+func NewUploadOptions(conf *config.Config) (*UploadOptions, error) {
+	opts := &UploadOptions{
+		SSE:                 conf.AWSSSE,
+		KMSKeyID:            conf.AWSSSEKMSKeyID,
+		StorageClass:        conf.AWSStorageClass,
+		Tags:                conf.AWSObjectTags,
+		ObjectLockMode:      strings.ToUpper(conf.AWSObjectLockMode),
+		ObjectLockRetention: conf.AWSObjectLockRetention,
+	}
+	switch opts.SSE {
+	case "", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms:
+	case "customer":
+		if conf.AWSSSECustomerKeyFile == "" {
+			return nil, fmt.Errorf("AWS_SSE_CUSTOMER_KEY_FILE is required by SSE-C")
+		}
+		content, err := ioutil.ReadFile(conf.AWSSSECustomerKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read SSE-C key: %s", err.Error())
+		}
+		opts.CustomerKey, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(content)))
+		if err != nil {
+			return nil, fmt.Errorf("SSE-C key must be base64 encoded: %s", err.Error())
+		}
+		if len(opts.CustomerKey) != 32 {
+			return nil, fmt.Errorf("SSE-C key must be 32 bytes long, got %d", len(opts.CustomerKey))
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported AWS_SSE %s (available: AES256, aws:kms, customer)", opts.SSE)
+	}
+	if opts.KMSKeyID != "" && opts.SSE != s3.ServerSideEncryptionAwsKms {
+		return nil, fmt.Errorf("AWS_SSE_KMS_KEY_ID requires AWS_SSE=aws:kms")
+	}
+	switch opts.ObjectLockMode {
+	case "":
+		if conf.AWSObjectLockRetention != 0 || conf.AWSObjectLockRetainUntil != "" {
+			return nil, fmt.Errorf("AWS_OBJECT_LOCK_MODE is required to set a retention")
+		}
+	case s3.ObjectLockModeGovernance, s3.ObjectLockModeCompliance:
+		if conf.AWSObjectLockRetainUntil != "" {
+			until, err := time.Parse(time.RFC3339, conf.AWSObjectLockRetainUntil)
+			if err != nil {
+				return nil, fmt.Errorf("AWS_OBJECT_LOCK_RETAIN_UNTIL must be a RFC3339 date: %s", err.Error())
+			}
+			opts.ObjectLockRetainUntil = until
+		}
+		if opts.ObjectLockRetention <= 0 && opts.ObjectLockRetainUntil.IsZero() {
+			return nil, fmt.Errorf("AWS_OBJECT_LOCK_RETENTION or AWS_OBJECT_LOCK_RETAIN_UNTIL is required by Object Lock")
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported AWS_OBJECT_LOCK_MODE %s (available: GOVERNANCE, COMPLIANCE)", conf.AWSObjectLockMode)
+	}
+	return opts, nil
+}
+
+// retainUntil - Compute the Object Lock retention date of an object uploaded now, the latest of the relative and
+// the fixed retention wins when both are set.
+func (o *UploadOptions) retainUntil(now time.Time) time.Time {
+	if o.ObjectLockRetention > 0 {
+		until := now.Add(o.ObjectLockRetention)
+		if until.After(o.ObjectLockRetainUntil) {
+			return until
+		}
+	}
+	return o.ObjectLockRetainUntil
+}
+
+// tagging - Build the URL encoded tag set from the configured tags and the object metadata.
+func (o *UploadOptions) tagging(metadata map[string]string) string {
+	tags := url.Values{}
+	for k, v := range o.Tags {
+		tags.Set(k, v)
+	}
+	for _, k := range taggedMetadata {
+		if v, ok := metadata[k]; ok && v != "" {
+			tags.Set(k, v)
+		}
+	}
+	return tags.Encode()
+}
+
+// Put - Upload body into the bucket under the given key.
+func (b *Backend) Put(ctx context.Context, key string, body io.Reader, metadata map[string]string) error {
+	input := &s3manager.UploadInput{
+		Bucket:   aws.String(b.Bucket),
+		Key:      aws.String(key),
+		Body:     body,
+		Metadata: aws.StringMap(metadata),
+	}
+	if tagging := b.Upload.tagging(metadata); tagging != "" {
+		input.Tagging = aws.String(tagging)
+	}
+	if b.Upload.StorageClass != "" {
+		input.StorageClass = aws.String(b.Upload.StorageClass)
+	}
+	switch b.Upload.SSE {
+	case "customer":
+		input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.SSECustomerKey = aws.String(string(b.Upload.CustomerKey))
+	case s3.ServerSideEncryptionAwsKms:
+		input.ServerSideEncryption = aws.String(b.Upload.SSE)
+		if b.Upload.KMSKeyID != "" {
+			input.SSEKMSKeyId = aws.String(b.Upload.KMSKeyID)
+		}
+	case s3.ServerSideEncryptionAes256:
+		input.ServerSideEncryption = aws.String(b.Upload.SSE)
+	}
+	if b.Upload.ObjectLockMode != "" {
+		// Object Lock requires a Content-MD5 header, key files are small enough to be buffered.
+		content, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		sum := md5.Sum(content)
+		input.Body = bytes.NewReader(content)
+		input.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+		input.ObjectLockMode = aws.String(b.Upload.ObjectLockMode)
+		input.ObjectLockRetainUntilDate = aws.Time(b.Upload.retainUntil(time.Now()))
+	}
+	uploader := s3manager.NewUploader(b.Session)
+	_, err := uploader.UploadWithContext(ctx, input)
+	return err
+}
+
+// Get - Download an object from the bucket.
+func (b *Backend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(key),
+	}
+	if b.Upload.SSE == "customer" {
+		input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.SSECustomerKey = aws.String(string(b.Upload.CustomerKey))
+	}
+	out, err := b.Client.GetObjectWithContext(ctx, input)
 	if err != nil {
-		return err
+		return nil, convertError(err)
+	}
+	return out.Body, nil
+}
+
+// List - List all objects of the bucket starting with prefix.
+func (b *Backend) List(ctx context.Context, prefix string) ([]backend.ObjectInfo, error) {
+	var objects []backend.ObjectInfo
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.Bucket),
+		Prefix: aws.String(prefix),
+	}
+	err := b.Client.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, item := range page.Contents {
+			objects = append(objects, backend.ObjectInfo{
+				Key:          aws.StringValue(item.Key),
+				Size:         aws.Int64Value(item.Size),
+				LastModified: aws.TimeValue(item.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return objects, nil
+}
+
+// Delete - Remove an object from the bucket.
+func (b *Backend) Delete(ctx context.Context, key string) error {
+	_, err := b.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(key),
+	})
+	return convertError(err)
+}
+
+// Stat - Retrieve object information with a HEAD request.
+func (b *Backend) Stat(ctx context.Context, key string) (*backend.ObjectInfo, error) {
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(key),
+	}
+	if b.Upload.SSE == "customer" {
+		input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.SSECustomerKey = aws.String(string(b.Upload.CustomerKey))
+	}
+	out, err := b.Client.HeadObjectWithContext(ctx, input)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return &backend.ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(out.ContentLength),
+		LastModified: aws.TimeValue(out.LastModified),
+		Metadata:     aws.StringValueMap(out.Metadata),
+	}, nil
+}
+
+// convertError - Map S3 "not found" errors to backend.ErrNotFound.
+func convertError(err error) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return backend.ErrNotFound
+		}
 	}
-	return nil
+	return err
 }
@@ -0,0 +1,356 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+)
+
+// fakeServer - Minimal in-memory S3 server, recording the requests it receives.
+type fakeServer struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	requests []*http.Request
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r)
+	switch r.Method {
+	case http.MethodPut:
+		content, _ := ioutil.ReadAll(r.Body)
+		f.objects[r.URL.Path] = content
+	case http.MethodHead:
+		content, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Header().Set("Last-Modified", "Fri, 01 Jan 2021 00:00:00 GMT")
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// lastRequest - Return the last request received with the given method.
+func (f *fakeServer) lastRequest(method string) *http.Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.requests) - 1; i >= 0; i-- {
+		if f.requests[i].Method == method {
+			return f.requests[i]
+		}
+	}
+	return nil
+}
+
+// setCredentials - Set static AWS credentials in the environment, the returned function restores it.
+func setCredentials() func() {
+	vars := map[string]string{
+		"AWS_ACCESS_KEY_ID":           "access",
+		"AWS_SECRET_ACCESS_KEY":       "secret",
+		"AWS_SESSION_TOKEN":           "",
+		"AWS_PROFILE":                 "",
+		"AWS_CONFIG_FILE":             os.DevNull,
+		"AWS_SHARED_CREDENTIALS_FILE": os.DevNull,
+	}
+	previous := map[string]*string{}
+	for name, value := range vars {
+		if old, ok := os.LookupEnv(name); ok {
+			previous[name] = &old
+		} else {
+			previous[name] = nil
+		}
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, old := range previous {
+			if old == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *old)
+			}
+		}
+	}
+}
+
+// writeCA - Write the certificate of a TLS test server into dir and return its path.
+func writeCA(t *testing.T, dir string, srv *httptest.Server) string {
+	path := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(path, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCompatibleEndpoint(t *testing.T) {
+	defer setCredentials()()
+	fake := &fakeServer{objects: map[string][]byte{}}
+	srv := httptest.NewTLSServer(fake)
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "s3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sess, err := New(&Options{Region: "us-east-1", Endpoint: srv.URL, ForcePathStyle: true, CABundle: writeCA(t, dir, srv)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Backend{Session: sess, Client: s3.New(sess), Bucket: "bucket"}
+	ctx := context.Background()
+	if _, err := b.Stat(ctx, "key.yaml"); err != backend.ErrNotFound {
+		t.Fatalf("Stat of a missing object = %v, want ErrNotFound", err)
+	}
+	if err := b.Put(ctx, "kube-system/key.yaml", strings.NewReader("content"), nil); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	// Path-style addressing puts the bucket in the path, the host stays the endpoint one.
+	if req := fake.lastRequest(http.MethodPut); req.URL.Path != "/bucket/kube-system/key.yaml" || req.Host != strings.TrimPrefix(srv.URL, "https://") {
+		t.Errorf("PUT %s%s, want the bucket in the path of the endpoint", req.Host, req.URL.Path)
+	}
+	info, err := b.Stat(ctx, "kube-system/key.yaml")
+	if err != nil || info.Size != int64(len("content")) {
+		t.Errorf("Stat = %+v, %v", info, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	defer setCredentials()()
+	srv := httptest.NewTLSServer(&fakeServer{objects: map[string][]byte{}})
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "s3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	invalidPath := filepath.Join(dir, "invalid.pem")
+	if err := ioutil.WriteFile(invalidPath, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{name: "aws", opts: Options{Region: "eu-west-1"}},
+		{name: "endpoint without ssl", opts: Options{Region: "us-east-1", Endpoint: "http://minio:9000", ForcePathStyle: true, DisableSSL: true}},
+		{name: "missing ca bundle", opts: Options{Region: "us-east-1", CABundle: filepath.Join(dir, "missing.pem")}, wantErr: true},
+		{name: "invalid ca bundle", opts: Options{Region: "us-east-1", CABundle: invalidPath}, wantErr: true},
+	}
+	for _, tt := range tests {
+		opts := tt.opts
+		_, err := New(&opts)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: New error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// Without the CA bundle the certificate of the endpoint is not trusted.
+	sess, err := New(&Options{Region: "us-east-1", Endpoint: srv.URL, ForcePathStyle: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Backend{Session: sess, Client: s3.New(sess), Bucket: "bucket"}
+	if _, err := b.Stat(context.Background(), "key.yaml"); err == nil || err == backend.ErrNotFound {
+		t.Errorf("Stat with an untrusted certificate = %v, want a TLS error", err)
+	}
+}
+
+func TestNewUploadOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keys := map[string]string{
+		"key":       base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("k"), 32)) + "\n",
+		"short-key": base64.StdEncoding.EncodeToString([]byte("short")),
+		"plain-key": "not base64!",
+	}
+	for name, content := range keys {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		conf    config.Config
+		want    UploadOptions
+		wantErr bool
+	}{
+		{name: "defaults", conf: config.Config{}},
+		{
+			name: "sse-s3 and storage class",
+			conf: config.Config{AWSSSE: "AES256", AWSStorageClass: "STANDARD_IA"},
+			want: UploadOptions{SSE: "AES256", StorageClass: "STANDARD_IA"},
+		},
+		{
+			name: "sse-kms with a key",
+			conf: config.Config{AWSSSE: "aws:kms", AWSSSEKMSKeyID: "alias/backups"},
+			want: UploadOptions{SSE: "aws:kms", KMSKeyID: "alias/backups"},
+		},
+		{name: "kms key without sse-kms", conf: config.Config{AWSSSE: "AES256", AWSSSEKMSKeyID: "alias/backups"}, wantErr: true},
+		{
+			name: "sse-c",
+			conf: config.Config{AWSSSE: "customer", AWSSSECustomerKeyFile: filepath.Join(dir, "key")},
+			want: UploadOptions{SSE: "customer", CustomerKey: bytes.Repeat([]byte("k"), 32)},
+		},
+		{name: "sse-c without key", conf: config.Config{AWSSSE: "customer"}, wantErr: true},
+		{name: "sse-c with a missing key", conf: config.Config{AWSSSE: "customer", AWSSSECustomerKeyFile: filepath.Join(dir, "missing")}, wantErr: true},
+		{name: "sse-c with a short key", conf: config.Config{AWSSSE: "customer", AWSSSECustomerKeyFile: filepath.Join(dir, "short-key")}, wantErr: true},
+		{name: "sse-c with a key not encoded", conf: config.Config{AWSSSE: "customer", AWSSSECustomerKeyFile: filepath.Join(dir, "plain-key")}, wantErr: true},
+		{name: "unknown sse", conf: config.Config{AWSSSE: "aws:kms:dsse"}, wantErr: true},
+		{
+			name: "object lock retention",
+			conf: config.Config{AWSObjectLockMode: "governance", AWSObjectLockRetention: 24 * time.Hour},
+			want: UploadOptions{ObjectLockMode: "GOVERNANCE", ObjectLockRetention: 24 * time.Hour},
+		},
+		{
+			name: "object lock retain until",
+			conf: config.Config{AWSObjectLockMode: "COMPLIANCE", AWSObjectLockRetainUntil: "2030-01-01T00:00:00Z"},
+			want: UploadOptions{ObjectLockMode: "COMPLIANCE", ObjectLockRetainUntil: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		{name: "object lock without retention", conf: config.Config{AWSObjectLockMode: "COMPLIANCE"}, wantErr: true},
+		{name: "object lock with an invalid date", conf: config.Config{AWSObjectLockMode: "COMPLIANCE", AWSObjectLockRetainUntil: "2030-01-01"}, wantErr: true},
+		{name: "retention without object lock", conf: config.Config{AWSObjectLockRetention: time.Hour}, wantErr: true},
+		{name: "unknown object lock mode", conf: config.Config{AWSObjectLockMode: "legal-hold", AWSObjectLockRetention: time.Hour}, wantErr: true},
+	}
+	for _, tt := range tests {
+		conf := tt.conf
+		got, err := NewUploadOptions(&conf)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: NewUploadOptions error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("%s: NewUploadOptions = %+v, want %+v", tt.name, *got, tt.want)
+		}
+	}
+}
+
+func TestTagging(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     map[string]string
+		metadata map[string]string
+		want     string
+	}{
+		{name: "none", metadata: map[string]string{"secret": "key"}, want: ""},
+		{name: "configured tags", tags: map[string]string{"team": "platform", "env": "prod"}, want: "env=prod&team=platform"},
+		{
+			name:     "metadata tags",
+			metadata: map[string]string{"cluster": "prod eu", "controller": "sealed-secrets", "fingerprint": "", "secret": "key"},
+			want:     "cluster=prod+eu&controller=sealed-secrets",
+		},
+		{
+			name:     "metadata overrides configured tags",
+			tags:     map[string]string{"cluster": "default", "team": "platform"},
+			metadata: map[string]string{"cluster": "prod", "fingerprint": "ab:cd"},
+			want:     "cluster=prod&fingerprint=ab%3Acd&team=platform",
+		},
+	}
+	for _, tt := range tests {
+		opts := &UploadOptions{Tags: tt.tags}
+		if got := opts.tagging(tt.metadata); got != tt.want {
+			t.Errorf("%s: tagging = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetainUntil(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		retention time.Duration
+		until     time.Time
+		want      time.Time
+	}{
+		{name: "retention", retention: 48 * time.Hour, want: now.Add(48 * time.Hour)},
+		{name: "retain until", until: now.AddDate(1, 0, 0), want: now.AddDate(1, 0, 0)},
+		{name: "retention after retain until", retention: 48 * time.Hour, until: now.Add(time.Hour), want: now.Add(48 * time.Hour)},
+		{name: "retain until after retention", retention: time.Hour, until: now.AddDate(1, 0, 0), want: now.AddDate(1, 0, 0)},
+	}
+	for _, tt := range tests {
+		opts := &UploadOptions{ObjectLockRetention: tt.retention, ObjectLockRetainUntil: tt.until}
+		if got := opts.retainUntil(now); !got.Equal(tt.want) {
+			t.Errorf("%s: retainUntil = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPutUploadOptions(t *testing.T) {
+	defer setCredentials()()
+	// SSE-C keys are only sent over HTTPS.
+	fake := &fakeServer{objects: map[string][]byte{}}
+	srv := httptest.NewTLSServer(fake)
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "s3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sess, err := New(&Options{Region: "us-east-1", Endpoint: srv.URL, ForcePathStyle: true, CABundle: writeCA(t, dir, srv)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	customerKey := bytes.Repeat([]byte("k"), 32)
+	b := &Backend{Session: sess, Client: s3.New(sess), Bucket: "bucket", Upload: UploadOptions{
+		SSE:                 "customer",
+		CustomerKey:         customerKey,
+		StorageClass:        "GLACIER_IR",
+		Tags:                map[string]string{"team": "platform"},
+		ObjectLockMode:      "COMPLIANCE",
+		ObjectLockRetention: 24 * time.Hour,
+	}}
+	before := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	if err := b.Put(context.Background(), "key.yaml", strings.NewReader("content"), map[string]string{"cluster": "prod"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	req := fake.lastRequest(http.MethodPut)
+	sum := md5.Sum([]byte("content"))
+	keySum := md5.Sum(customerKey)
+	want := map[string]string{
+		"Content-Md5":         base64.StdEncoding.EncodeToString(sum[:]),
+		"X-Amz-Meta-Cluster":  "prod",
+		"X-Amz-Tagging":       "cluster=prod&team=platform",
+		"X-Amz-Storage-Class": "GLACIER_IR",
+		"X-Amz-Server-Side-Encryption-Customer-Algorithm": "AES256",
+		"X-Amz-Server-Side-Encryption-Customer-Key":       base64.StdEncoding.EncodeToString(customerKey),
+		"X-Amz-Server-Side-Encryption-Customer-Key-Md5":   base64.StdEncoding.EncodeToString(keySum[:]),
+		"X-Amz-Object-Lock-Mode":                          "COMPLIANCE",
+	}
+	for name, value := range want {
+		if got := req.Header.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+	until, err := time.Parse(time.RFC3339, req.Header.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+	if err != nil || until.Before(before) || until.After(before.Add(time.Minute)) {
+		t.Errorf("X-Amz-Object-Lock-Retain-Until-Date = %s, %v, want about %s", until, err, before)
+	}
+}
@@ -0,0 +1,382 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	payloadField  = "payload"
+	metadataField = "metadata"
+)
+
+// Options - Settings used to init the Vault backend.
+type Options struct {
+	Address   string
+	Namespace string
+	// CACert - PEM file used to verify the Vault server certificate.
+	CACert string
+	// AuthMethod - token, approle or kubernetes.
+	AuthMethod string
+	// AuthMount - Mount path of the auth method, defaults to the method name.
+	AuthMount           string
+	Token               string
+	RoleID              string
+	SecretID            string
+	KubernetesRole      string
+	KubernetesTokenPath string
+	// KVMount - Mount path of the KV v2 secret engine.
+	KVMount string
+	// Path - Path under the KV mount where keys are written.
+	Path string
+}
+
+// Backend - HashiCorp Vault KV v2 implementation of backend.Backend.
+type Backend struct {
+	Client  *http.Client
+	Options *Options
+
+	mu    sync.RWMutex
+	token string
+}
+
+type response struct {
+	Data json.RawMessage `json:"data"`
+	Auth struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+type secretData struct {
+	Data     map[string]interface{} `json:"data"`
+	Metadata struct {
+		CreatedTime time.Time `json:"created_time"`
+	} `json:"metadata"`
+}
+
+func init() {
+	backend.Register("vault", NewBackend)
+}
+
+// New - Init a Vault backend and authenticate against the server.
+func New(opts *Options) (*Backend, error) {
+	if opts.Address == "" {
+		return nil, fmt.Errorf("No Vault address has been provided")
+	}
+	opts.Address = strings.TrimSuffix(opts.Address, "/")
+	if opts.AuthMount == "" {
+		opts.AuthMount = opts.AuthMethod
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	if opts.CACert != "" {
+		pem, err := ioutil.ReadFile(opts.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read CA certificate %s: %s", opts.CACert, err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificate found in %s", opts.CACert)
+		}
+		client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}
+	}
+
+	b := &Backend{Client: client, Options: opts}
+	err := b.login(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// NewBackend - Init the Vault backend from the current state.
+func NewBackend(state *config.State) (backend.Backend, error) {
+	return New(&Options{
+		Address:             state.Config.VaultAddress,
+		Namespace:           state.Config.VaultNamespace,
+		CACert:              state.Config.VaultCACert,
+		AuthMethod:          state.Config.VaultAuthMethod,
+		AuthMount:           state.Config.VaultAuthMount,
+		Token:               state.Config.VaultToken,
+		RoleID:              state.Config.VaultRoleID,
+		SecretID:            state.Config.VaultSecretID,
+		KubernetesRole:      state.Config.VaultKubernetesRole,
+		KubernetesTokenPath: state.Config.VaultKubernetesTokenPath,
+		KVMount:             state.Config.VaultKVMount,
+		Path:                state.Config.VaultKVPath,
+	})
+}
+
+// login - Retrieve a client token with the configured auth method.
+func (b *Backend) login(ctx context.Context) error {
+	var payload map[string]string
+	switch b.Options.AuthMethod {
+	case "token":
+		if b.Options.Token == "" {
+			return fmt.Errorf("VAULT_TOKEN is required by the token auth method")
+		}
+		b.setToken(b.Options.Token)
+		return nil
+	case "approle":
+		payload = map[string]string{
+			"role_id":   b.Options.RoleID,
+			"secret_id": b.Options.SecretID,
+		}
+	case "kubernetes":
+		jwt, err := ioutil.ReadFile(b.Options.KubernetesTokenPath)
+		if err != nil {
+			return fmt.Errorf("Unable to read service account token: %s", err.Error())
+		}
+		payload = map[string]string{
+			"role": b.Options.KubernetesRole,
+			"jwt":  strings.TrimSpace(string(jwt)),
+		}
+	default:
+		return fmt.Errorf("Unsupported Vault auth method %s", b.Options.AuthMethod)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := b.request(ctx, http.MethodPost, path.Join("auth", b.Options.AuthMount, "login"), body, false)
+	if err != nil {
+		return fmt.Errorf("Unable to login to Vault with %s auth method: %s", b.Options.AuthMethod, err.Error())
+	}
+	if resp.Auth.ClientToken == "" {
+		return fmt.Errorf("Vault login with %s auth method returned no token", b.Options.AuthMethod)
+	}
+	b.setToken(resp.Auth.ClientToken)
+	return nil
+}
+
+func (b *Backend) setToken(token string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.token = token
+}
+
+func (b *Backend) getToken() string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.token
+}
+
+// request - Call the Vault API. An expired token is renewed once by logging in again.
+func (b *Backend) request(ctx context.Context, method, apiPath string, body []byte, authenticated bool) (*response, error) {
+	status, out, err := b.send(ctx, method, apiPath, body, authenticated)
+	if status == http.StatusForbidden && authenticated && b.Options.AuthMethod != "token" {
+		err = b.login(ctx)
+		if err != nil {
+			return nil, err
+		}
+		_, out, err = b.send(ctx, method, apiPath, body, authenticated)
+	}
+	return out, err
+}
+
+// send - Send a single request to the Vault API and decode its response.
+func (b *Backend) send(ctx context.Context, method, apiPath string, body []byte, authenticated bool) (int, *response, error) {
+	req, err := http.NewRequest(method, b.Options.Address+"/v1/"+apiPath, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	if authenticated {
+		req.Header.Set("X-Vault-Token", b.getToken())
+	}
+	if b.Options.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", b.Options.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := b.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return resp.StatusCode, nil, backend.ErrNotFound
+	}
+	var out response
+	if resp.StatusCode != http.StatusNoContent {
+		err = json.NewDecoder(resp.Body).Decode(&out)
+		if err != nil && err != io.EOF {
+			return resp.StatusCode, nil, fmt.Errorf("Unable to decode Vault response: %s", err.Error())
+		}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, nil, fmt.Errorf("Vault request %s %s failed with status %d: %s", method, apiPath, resp.StatusCode, strings.Join(out.Errors, ", "))
+	}
+	return resp.StatusCode, &out, nil
+}
+
+func (b *Backend) dataPath(key string) string {
+	return path.Join(b.Options.KVMount, "data", b.Options.Path, key)
+}
+
+func (b *Backend) metadataPath(key string) string {
+	return path.Join(b.Options.KVMount, "metadata", b.Options.Path, key)
+}
+
+// Put - Write the backup into the KV engine. When the payload is a kubernetes.io/tls secret, its tls.crt and
+// tls.key are also stored as separate fields so they can be read directly from Vault.
+func (b *Backend) Put(ctx context.Context, key string, body io.Reader, metadata map[string]string) error {
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	data := map[string]interface{}{
+		payloadField:  base64.StdEncoding.EncodeToString(content),
+		metadataField: metadata,
+	}
+	var secret v1.Secret
+	if err := yaml.Unmarshal(content, &secret); err == nil && secret.Type == v1.SecretTypeTLS {
+		data[v1.TLSCertKey] = string(secret.Data[v1.TLSCertKey])
+		data[v1.TLSPrivateKeyKey] = string(secret.Data[v1.TLSPrivateKeyKey])
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		return err
+	}
+	_, err = b.request(ctx, http.MethodPost, b.dataPath(key), payload, true)
+	return err
+}
+
+// read - Read the latest version of a KV entry.
+func (b *Backend) read(ctx context.Context, key string) (*secretData, []byte, error) {
+	resp, err := b.request(ctx, http.MethodGet, b.dataPath(key), nil, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	var secret secretData
+	err = json.Unmarshal(resp.Data, &secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Deleted versions are returned with a nil data field.
+	encoded, ok := secret.Data[payloadField].(string)
+	if !ok {
+		return nil, nil, backend.ErrNotFound
+	}
+	content, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid payload stored at %s: %s", key, err.Error())
+	}
+	return &secret, content, nil
+}
+
+// Get - Return the payload stored under the given key.
+func (b *Backend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	_, content, err := b.read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+// List - Recursively list every entry whose key starts with prefix.
+func (b *Backend) List(ctx context.Context, prefix string) ([]backend.ObjectInfo, error) {
+	dir := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i+1]
+	}
+	var objects []backend.ObjectInfo
+	err := b.walk(ctx, dir, func(key string) error {
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := b.Stat(ctx, key)
+		if err == backend.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		objects = append(objects, *info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// walk - Call fn for every entry found under dir.
+func (b *Backend) walk(ctx context.Context, dir string, fn func(key string) error) error {
+	resp, err := b.request(ctx, "LIST", b.metadataPath(dir), nil, true)
+	if err == backend.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list struct {
+		Keys []string `json:"keys"`
+	}
+	err = json.Unmarshal(resp.Data, &list)
+	if err != nil {
+		return err
+	}
+	for _, item := range list.Keys {
+		if strings.HasSuffix(item, "/") {
+			err = b.walk(ctx, dir+item, fn)
+		} else {
+			err = fn(dir + item)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete - Permanently remove every version of an entry.
+func (b *Backend) Delete(ctx context.Context, key string) error {
+	_, err := b.request(ctx, http.MethodDelete, b.metadataPath(key), nil, true)
+	return err
+}
+
+// Stat - Retrieve information about the latest version of an entry.
+func (b *Backend) Stat(ctx context.Context, key string) (*backend.ObjectInfo, error) {
+	secret, content, err := b.read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	info := &backend.ObjectInfo{
+		Key:          key,
+		Size:         int64(len(content)),
+		LastModified: secret.Metadata.CreatedTime,
+		Metadata:     make(map[string]string),
+	}
+	if metadata, ok := secret.Data[metadataField].(map[string]interface{}); ok {
+		for k, v := range metadata {
+			info.Metadata[k] = fmt.Sprint(v)
+		}
+	}
+	return info, nil
+}
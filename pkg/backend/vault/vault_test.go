@@ -0,0 +1,297 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+)
+
+// fakeServer - Minimal in-memory implementation of the Vault KV v2 and login APIs, with the secret engine mounted
+// on kv.
+type fakeServer struct {
+	mu      sync.Mutex
+	entries map[string]map[string]interface{}
+	// logins - Expected login payload of each auth mount.
+	logins map[string]map[string]string
+	token  string
+	// namespace - Namespace header received with the last request.
+	namespace string
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.namespace = r.Header.Get("X-Vault-Namespace")
+	const data, metadata = "/v1/kv/data/", "/v1/kv/metadata/"
+	if strings.HasPrefix(r.URL.Path, "/v1/auth/") && strings.HasSuffix(r.URL.Path, "/login") {
+		mount := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/auth/"), "/login")
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		if want, ok := f.logins[mount]; !ok || !reflect.DeepEqual(payload, want) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string][]string{"errors": {"invalid credentials"}})
+			return
+		}
+		f.token = "token-" + mount
+		json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]string{"client_token": f.token}})
+		return
+	}
+	if r.Header.Get("X-Vault-Token") != f.token {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, data):
+		var body struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.entries[strings.TrimPrefix(r.URL.Path, data)] = body.Data
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]int{"version": 1}})
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, data):
+		entry, ok := f.entries[strings.TrimPrefix(r.URL.Path, data)]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"data":     entry,
+			"metadata": map[string]interface{}{"created_time": time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), "version": 1},
+		}})
+	case r.Method == "LIST" && strings.HasPrefix(r.URL.Path, metadata):
+		dir := strings.TrimPrefix(r.URL.Path, metadata)
+		if dir != "" && !strings.HasSuffix(dir, "/") {
+			dir += "/"
+		}
+		seen := map[string]bool{}
+		var keys []string
+		for name := range f.entries {
+			if !strings.HasPrefix(name, dir) {
+				continue
+			}
+			item := strings.TrimPrefix(name, dir)
+			if i := strings.Index(item, "/"); i >= 0 {
+				item = item[:i+1]
+			}
+			if !seen[item] {
+				seen[item] = true
+				keys = append(keys, item)
+			}
+		}
+		if len(keys) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		sort.Strings(keys)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string][]string{"keys": keys}})
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, metadata):
+		delete(f.entries, strings.TrimPrefix(r.URL.Path, metadata))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{
+		entries: map[string]map[string]interface{}{},
+		logins:  map[string]map[string]string{},
+		token:   "root",
+	}
+}
+
+func TestBackend(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeServer()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	b, err := New(&Options{
+		Address:    srv.URL + "/",
+		Namespace:  "team",
+		AuthMethod: "token",
+		Token:      "root",
+		KVMount:    "kv",
+		Path:       "backups",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Stat(ctx, "a.yaml"); err != backend.ErrNotFound {
+		t.Fatalf("Stat of a missing entry = %v, want ErrNotFound", err)
+	}
+	if objects, err := b.List(ctx, ""); err != nil || len(objects) != 0 {
+		t.Fatalf("List of an empty path = %v, %v", objects, err)
+	}
+	for _, key := range []string{"a.yaml", "kube-system/b.yaml", "kube-system/c.yaml"} {
+		if err := b.Put(ctx, key, strings.NewReader("content of "+key), map[string]string{"secret": key}); err != nil {
+			t.Fatalf("Put(%s): %v", key, err)
+		}
+	}
+	if fake.namespace != "team" {
+		t.Errorf("X-Vault-Namespace = %q, want team", fake.namespace)
+	}
+	if _, ok := fake.entries["backups/kube-system/b.yaml"]; !ok {
+		t.Errorf("entry not written under the KV path: %v", fake.entries)
+	}
+
+	info, err := b.Stat(ctx, "kube-system/b.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"secret": "kube-system/b.yaml"}
+	if info.Key != "kube-system/b.yaml" || info.Size != int64(len("content of kube-system/b.yaml")) ||
+		!info.LastModified.Equal(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)) || !reflect.DeepEqual(info.Metadata, want) {
+		t.Errorf("Stat = %+v", info)
+	}
+	reader, err := b.Get(ctx, "a.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil || string(content) != "content of a.yaml" {
+		t.Errorf("Get = %q, %v", content, err)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "", want: []string{"a.yaml", "kube-system/b.yaml", "kube-system/c.yaml"}},
+		{prefix: "kube-system/", want: []string{"kube-system/b.yaml", "kube-system/c.yaml"}},
+		{prefix: "kube-system/b", want: []string{"kube-system/b.yaml"}},
+		{prefix: "missing/", want: nil},
+	}
+	for _, tt := range tests {
+		objects, err := b.List(ctx, tt.prefix)
+		if err != nil {
+			t.Fatalf("List(%q): %v", tt.prefix, err)
+		}
+		var keys []string
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+		if !reflect.DeepEqual(keys, tt.want) {
+			t.Errorf("List(%q) = %v, want %v", tt.prefix, keys, tt.want)
+		}
+	}
+
+	if err := b.Delete(ctx, "kube-system/b.yaml"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := b.Get(ctx, "kube-system/b.yaml"); err != backend.ErrNotFound {
+		t.Errorf("Get of a deleted entry = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPutTLSSecret(t *testing.T) {
+	fake := newFakeServer()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	b, err := New(&Options{Address: srv.URL, AuthMethod: "token", Token: "root", KVMount: "kv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := "apiVersion: v1\nkind: Secret\ntype: kubernetes.io/tls\ndata:\n  tls.crt: Y2VydA==\n  tls.key: a2V5\n"
+	if err := b.Put(context.Background(), "key.yaml", strings.NewReader(secret), nil); err != nil {
+		t.Fatal(err)
+	}
+	entry := fake.entries["key.yaml"]
+	if entry["tls.crt"] != "cert" || entry["tls.key"] != "key" {
+		t.Errorf("entry = %v, want the tls.crt and tls.key fields", entry)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenPath := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenPath, []byte("service-account-jwt\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		opts      Options
+		wantToken string
+		wantErr   bool
+	}{
+		{
+			name:      "approle",
+			opts:      Options{AuthMethod: "approle", RoleID: "role", SecretID: "secret"},
+			wantToken: "token-approle",
+		},
+		{
+			name:      "approle on a custom mount",
+			opts:      Options{AuthMethod: "approle", AuthMount: "ci/approle", RoleID: "role", SecretID: "secret"},
+			wantToken: "token-ci/approle",
+		},
+		{
+			name:    "approle with a wrong secret",
+			opts:    Options{AuthMethod: "approle", RoleID: "role", SecretID: "wrong"},
+			wantErr: true,
+		},
+		{
+			name:      "kubernetes",
+			opts:      Options{AuthMethod: "kubernetes", KubernetesRole: "backuper", KubernetesTokenPath: tokenPath},
+			wantToken: "token-kubernetes",
+		},
+		{
+			name:    "kubernetes without token file",
+			opts:    Options{AuthMethod: "kubernetes", KubernetesRole: "backuper", KubernetesTokenPath: filepath.Join(dir, "missing")},
+			wantErr: true,
+		},
+		{name: "token without token", opts: Options{AuthMethod: "token"}, wantErr: true},
+		{name: "unknown method", opts: Options{AuthMethod: "ldap"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		fake := newFakeServer()
+		fake.token = ""
+		approle := map[string]string{"role_id": "role", "secret_id": "secret"}
+		fake.logins["approle"] = approle
+		fake.logins["ci/approle"] = approle
+		fake.logins["kubernetes"] = map[string]string{"role": "backuper", "jwt": "service-account-jwt"}
+		srv := httptest.NewServer(fake)
+
+		opts := tt.opts
+		opts.Address = srv.URL
+		opts.KVMount = "kv"
+		b, err := New(&opts)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: New error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if err == nil {
+			if token := b.getToken(); token != tt.wantToken {
+				t.Errorf("%s: token = %q, want %q", tt.name, token, tt.wantToken)
+			}
+			// An expired token is renewed by logging in again.
+			fake.token = "renewed"
+			if _, err := b.Stat(context.Background(), "a.yaml"); err != backend.ErrNotFound {
+				t.Errorf("%s: Stat after the token expired = %v, want ErrNotFound", tt.name, err)
+			}
+		}
+		srv.Close()
+	}
+}
@@ -0,0 +1,338 @@
+package catalog
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+	log "github.com/sirupsen/logrus"
+)
+
+const version = 1
+
+// Entry - Description of a backed-up kubeseal key.
+type Entry struct {
+	// Key - Location of the object in the backend.
+	Key         string    `json:"key"`
+	SecretName  string    `json:"secretName"`
+	Namespace   string    `json:"namespace"`
+	Controller  string    `json:"controller"`
+	Cluster     string    `json:"cluster,omitempty"`
+	Fingerprint string    `json:"fingerprint"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	CreatedAt   time.Time `json:"createdAt"`
+	// Checksum - SHA-256 of the stored object, after encryption.
+	Checksum   string    `json:"checksum"`
+	Size       int64     `json:"size"`
+	Encryption string    `json:"encryption,omitempty"`
+	BackedUpAt time.Time `json:"backedUpAt"`
+}
+
+// Bundle - Description of a backed-up bundle, holding several kubeseal keys in one object.
+type Bundle struct {
+	// Key - Location of the object in the backend.
+	Key        string `json:"key"`
+	Namespace  string `json:"namespace"`
+	Controller string `json:"controller"`
+	Cluster    string `json:"cluster,omitempty"`
+	// Fingerprints - Fingerprints of the keys held by the bundle.
+	Fingerprints []string  `json:"fingerprints"`
+	BackedUpAt   time.Time `json:"backedUpAt"`
+}
+
+// Catalog - Index of every key stored in a backend.
+type Catalog struct {
+	Version int       `json:"version"`
+	Updated time.Time `json:"updated"`
+	Keys    []Entry   `json:"keys"`
+	Bundles []Bundle  `json:"bundles,omitempty"`
+	// diverged - Set by Load when the copies of a mirror backend held different catalogs.
+	diverged bool
+}
+
+// Checksum - Compute the checksum recorded for an object.
+func Checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Load - Download the catalog stored under name, an empty catalog is returned when there is none yet. The catalogs
+// of every copy of a mirror backend are merged, a copy which cannot be read is skipped as long as another one can.
+func Load(ctx context.Context, b backend.Backend, name string) (*Catalog, error) {
+	mirror, ok := b.(backend.Mirror)
+	if !ok {
+		return load(ctx, b, name)
+	}
+	var catalogs []*Catalog
+	var firstErr error
+	for _, target := range mirror.Copies() {
+		c, err := load(ctx, target, name)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":   err.Error(),
+				"catalog": name,
+			}).Warning("Unable to load the catalog of a backend")
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		catalogs = append(catalogs, c)
+	}
+	if len(catalogs) == 0 {
+		return nil, firstErr
+	}
+	c, synced := Merge(catalogs)
+	c.diverged = !synced || firstErr != nil
+	return c, nil
+}
+
+// load - Download the catalog of a single backend.
+func load(ctx context.Context, b backend.Backend, name string) (*Catalog, error) {
+	reader, err := b.Get(ctx, name)
+	if err == backend.ErrNotFound {
+		return &Catalog{Version: version}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	var c Catalog
+	err = json.NewDecoder(reader).Decode(&c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Diverged - Check whether the copies of the backend held different catalogs, or could not all be read, in which
+// case the catalog must be saved even though no entry changed.
+func (c *Catalog) Diverged() bool {
+	return c.diverged
+}
+
+// Merge - Merge the catalogs of several copies of a backend. Entries are matched by key, the one with a checksum and
+// the latest backup date is kept. It returns false when the catalogs differed, so that the merged one must be saved.
+func Merge(catalogs []*Catalog) (*Catalog, bool) {
+	merged := &Catalog{Version: version}
+	index := map[string]int{}
+	bundles := map[string]int{}
+	for _, c := range catalogs {
+		for _, entry := range c.Keys {
+			i, ok := index[entry.Key]
+			if !ok {
+				index[entry.Key] = len(merged.Keys)
+				merged.Keys = append(merged.Keys, entry)
+				continue
+			}
+			current := merged.Keys[i]
+			if current.Checksum == "" || (entry.Checksum != "" && entry.BackedUpAt.After(current.BackedUpAt)) {
+				merged.Keys[i] = entry
+			}
+		}
+		for _, bundle := range c.Bundles {
+			i, ok := bundles[bundle.Key]
+			if !ok {
+				bundles[bundle.Key] = len(merged.Bundles)
+				merged.Bundles = append(merged.Bundles, bundle)
+				continue
+			}
+			if bundle.BackedUpAt.After(merged.Bundles[i].BackedUpAt) {
+				merged.Bundles[i] = bundle
+			}
+		}
+		if c.Updated.After(merged.Updated) {
+			merged.Updated = c.Updated
+		}
+	}
+
+	synced := true
+	for _, c := range catalogs {
+		if len(c.Keys) != len(merged.Keys) || len(c.Bundles) != len(merged.Bundles) {
+			synced = false
+			break
+		}
+		for _, entry := range c.Keys {
+			i, ok := index[entry.Key]
+			if !ok || entry.normalized() != merged.Keys[i].normalized() {
+				synced = false
+			}
+		}
+		for _, bundle := range c.Bundles {
+			i, ok := bundles[bundle.Key]
+			if !ok || !bundle.equal(merged.Bundles[i]) {
+				synced = false
+			}
+		}
+	}
+	return merged, synced
+}
+
+// Owned - Check whether the entry is a key of the given controller of the given cluster. Clusters sharing a backend
+// share the catalog, so the cluster must always be compared.
+func (e Entry) Owned(namespace string, controller string, cluster string) bool {
+	return e.Namespace == namespace && e.Controller == controller && e.Cluster == cluster
+}
+
+// Owned - Check whether the bundle holds keys of the given controller of the given cluster.
+func (b Bundle) Owned(namespace string, controller string, cluster string) bool {
+	return b.Namespace == namespace && b.Controller == controller && b.Cluster == cluster
+}
+
+// Holds - Check whether the bundle holds the key with the given fingerprint.
+func (b Bundle) Holds(fingerprint string) bool {
+	for _, f := range b.Fingerprints {
+		if f == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// equal - Compare two bundles, whatever the location of their dates.
+func (b Bundle) equal(other Bundle) bool {
+	if b.Key != other.Key || b.Namespace != other.Namespace || b.Controller != other.Controller ||
+		b.Cluster != other.Cluster || !b.BackedUpAt.Equal(other.BackedUpAt) || len(b.Fingerprints) != len(other.Fingerprints) {
+		return false
+	}
+	for i := range b.Fingerprints {
+		if b.Fingerprints[i] != other.Fingerprints[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Lookup - Return the entry of the object key.
+func (c *Catalog) Lookup(key string) (Entry, bool) {
+	for _, entry := range c.Keys {
+		if entry.Key == key {
+			return entry, true
+		}
+	}
+	return Entry{}, false
+}
+
+// LookupBundle - Return the entry of the bundle object key.
+func (c *Catalog) LookupBundle(key string) (Bundle, bool) {
+	for _, bundle := range c.Bundles {
+		if bundle.Key == key {
+			return bundle, true
+		}
+	}
+	return Bundle{}, false
+}
+
+// FindByFingerprint - Return the entries of the key whose certificate has the given fingerprint.
+func (c *Catalog) FindByFingerprint(fingerprint string) []Entry {
+	var entries []Entry
+	for _, entry := range c.Keys {
+		if entry.Fingerprint == fingerprint {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Upsert - Add or replace the entry of an object. The checksum, size and backup date of the current entry are kept
+// when the new one has none, which is the case when the upload has been skipped. It returns false when the catalog
+// already held the same entry.
+func (c *Catalog) Upsert(entry Entry) bool {
+	for i, current := range c.Keys {
+		if current.Key != entry.Key {
+			continue
+		}
+		if entry.Checksum == "" {
+			entry.Checksum = current.Checksum
+			entry.Size = current.Size
+			entry.BackedUpAt = current.BackedUpAt
+		}
+		if entry.normalized() == current.normalized() {
+			return false
+		}
+		c.Keys[i] = entry
+		return true
+	}
+	c.Keys = append(c.Keys, entry)
+	return true
+}
+
+// normalized - Copy of the entry whose dates can be compared with ==, whatever their location, as the ones decoded
+// from the catalog are in UTC.
+func (e Entry) normalized() Entry {
+	e.NotBefore = e.NotBefore.UTC()
+	e.NotAfter = e.NotAfter.UTC()
+	e.CreatedAt = e.CreatedAt.UTC()
+	e.BackedUpAt = e.BackedUpAt.UTC()
+	return e
+}
+
+// Remove - Drop the entry of an object. It returns false when there was none.
+func (c *Catalog) Remove(key string) bool {
+	for i, entry := range c.Keys {
+		if entry.Key == key {
+			c.Keys = append(c.Keys[:i], c.Keys[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// UpsertBundle - Add or replace the entry of a bundle, keeping the backup date of the current entry when the new one
+// has none. It returns false when the catalog already held the same entry.
+func (c *Catalog) UpsertBundle(bundle Bundle) bool {
+	for i, current := range c.Bundles {
+		if current.Key != bundle.Key {
+			continue
+		}
+		if bundle.BackedUpAt.IsZero() {
+			bundle.BackedUpAt = current.BackedUpAt
+		}
+		if bundle.equal(current) {
+			return false
+		}
+		c.Bundles[i] = bundle
+		return true
+	}
+	c.Bundles = append(c.Bundles, bundle)
+	return true
+}
+
+// RemoveBundle - Drop the entry of a bundle. It returns false when there was none.
+func (c *Catalog) RemoveBundle(key string) bool {
+	for i, bundle := range c.Bundles {
+		if bundle.Key == key {
+			c.Bundles = append(c.Bundles[:i], c.Bundles[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Save - Upload the catalog under name. Callers skip it when nothing changed, as every save updates the date.
+func (c *Catalog) Save(ctx context.Context, b backend.Backend, name string) error {
+	sort.Slice(c.Keys, func(i, j int) bool {
+		return c.Keys[i].Key < c.Keys[j].Key
+	})
+	sort.Slice(c.Bundles, func(i, j int) bool {
+		return c.Bundles[i].Key < c.Bundles[j].Key
+	})
+	c.Version = version
+	c.Updated = time.Now().UTC()
+	content, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = b.Put(ctx, name, bytes.NewReader(content), nil)
+	if err != nil {
+		return err
+	}
+	c.diverged = false
+	return nil
+}
@@ -0,0 +1,164 @@
+package catalog
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend/filesystem"
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend/multi"
+)
+
+func TestUpsert(t *testing.T) {
+	backedUp := time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)
+	current := Entry{
+		Key:         "kube-system/sealed-secrets/a.yaml",
+		SecretName:  "sealed-secrets-keya",
+		Fingerprint: "abc",
+		CreatedAt:   time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		Checksum:    "sha256:00",
+		Size:        10,
+		BackedUpAt:  backedUp,
+	}
+	skipped := current
+	skipped.Checksum, skipped.Size, skipped.BackedUpAt = "", 0, time.Time{}
+	local := skipped
+	local.CreatedAt = current.CreatedAt.In(time.FixedZone("CET", 3600))
+	renamed := current
+	renamed.SecretName = "sealed-secrets-keyb"
+	other := current
+	other.Key = "kube-system/sealed-secrets/b.yaml"
+
+	tests := []struct {
+		name    string
+		entry   Entry
+		changed bool
+	}{
+		{name: "same entry", entry: current},
+		{name: "skipped upload", entry: skipped},
+		{name: "same date in another location", entry: local},
+		{name: "changed field", entry: renamed, changed: true},
+		{name: "new object", entry: other, changed: true},
+	}
+	for _, tt := range tests {
+		c := &Catalog{Keys: []Entry{current}}
+		if changed := c.Upsert(tt.entry); changed != tt.changed {
+			t.Errorf("%s: Upsert = %v, want %v", tt.name, changed, tt.changed)
+		}
+		entry, ok := c.Lookup(current.Key)
+		if !ok || entry.Checksum != current.Checksum || !entry.BackedUpAt.Equal(backedUp) {
+			t.Errorf("%s: backup details of the current entry have been lost: %+v", tt.name, entry)
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	c := &Catalog{Keys: []Entry{{Key: "a"}, {Key: "b"}}}
+	if !c.Remove("a") {
+		t.Error("Remove of an existing entry = false, want true")
+	}
+	if c.Remove("a") {
+		t.Error("Remove of a missing entry = true, want false")
+	}
+	if len(c.Keys) != 1 || c.Keys[0].Key != "b" {
+		t.Errorf("Keys = %+v, want only b", c.Keys)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	older := Entry{Key: "a", Checksum: "sha256:00", BackedUpAt: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	newer := older
+	newer.Checksum, newer.BackedUpAt = "sha256:01", older.BackedUpAt.Add(time.Hour)
+	skipped := Entry{Key: "a"}
+	other := Entry{Key: "b", Checksum: "sha256:02"}
+
+	tests := []struct {
+		name     string
+		catalogs [][]Entry
+		want     []Entry
+		synced   bool
+	}{
+		{name: "same catalogs", catalogs: [][]Entry{{older, other}, {other, older}}, want: []Entry{older, other}, synced: true},
+		{name: "missing entry", catalogs: [][]Entry{{older}, {older, other}}, want: []Entry{older, other}},
+		{name: "empty catalog", catalogs: [][]Entry{{older}, nil}, want: []Entry{older}},
+		{name: "latest backup wins", catalogs: [][]Entry{{older}, {newer}}, want: []Entry{newer}},
+		{name: "checksum wins", catalogs: [][]Entry{{skipped}, {older}}, want: []Entry{older}},
+	}
+	for _, tt := range tests {
+		var catalogs []*Catalog
+		for _, keys := range tt.catalogs {
+			catalogs = append(catalogs, &Catalog{Keys: keys})
+		}
+		merged, synced := Merge(catalogs)
+		if synced != tt.synced {
+			t.Errorf("%s: synced = %v, want %v", tt.name, synced, tt.synced)
+		}
+		if len(merged.Keys) != len(tt.want) {
+			t.Errorf("%s: Keys = %+v, want %+v", tt.name, merged.Keys, tt.want)
+			continue
+		}
+		for _, want := range tt.want {
+			if entry, ok := merged.Lookup(want.Key); !ok || entry != want {
+				t.Errorf("%s: entry %s = %+v, want %+v", tt.name, want.Key, entry, want)
+			}
+		}
+	}
+}
+
+func TestLoadMirror(t *testing.T) {
+	ctx := context.Background()
+	var targets []multi.Target
+	for _, name := range []string{"primary", "dr"} {
+		dir, err := ioutil.TempDir("", "catalog")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		fs, err := filesystem.New(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets = append(targets, multi.Target{Name: name, Backend: fs})
+	}
+	b, err := multi.New(targets, "all")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the second backend holds a catalog, such as when the first one has just been added.
+	c := &Catalog{Keys: []Entry{{Key: "a", Checksum: "sha256:00"}}}
+	if err := c.Save(ctx, targets[1].Backend, "index.json"); err != nil {
+		t.Fatal(err)
+	}
+	c, err = Load(ctx, b, "index.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Lookup("a"); !ok || !c.Diverged() {
+		t.Fatalf("Load = %+v, diverged %v, want entry a and diverged", c.Keys, c.Diverged())
+	}
+	if err := c.Save(ctx, b, "index.json"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Diverged() {
+		t.Error("catalog still diverged after Save")
+	}
+	for _, target := range targets {
+		c, err := Load(ctx, target.Backend, "index.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := c.Lookup("a"); !ok {
+			t.Errorf("catalog of %s lacks entry a", target.Name)
+		}
+	}
+	c, err = Load(ctx, b, "index.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Diverged() {
+		t.Error("catalogs diverged after Save")
+	}
+}
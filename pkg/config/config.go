@@ -1,24 +1,108 @@
 package config
 
 import (
+	"time"
+
 	"github.com/aws/aws-sdk-go/aws/session"
-	slackclient "github.com/rayanebel/kubeseal-backuper/pkg/notifiers/slack"
 	"github.com/rayanebel/kubeseal-backuper/pkg/kube"
+	slackclient "github.com/rayanebel/kubeseal-backuper/pkg/notifiers/slack"
 )
 
 type Config struct {
-	KubernetesKubeconfigPath    string `envconfig:"KUBERNETES_KUBECONFIG_PATH"`
-	KubernetesClientMode        string `envconfig:"KUBERNETES_CLIENT_MODE" default:"internal"`
-	KubesealControllerName      string `envconfig:"KUBESEAL_CONTROLLER_NAME" default:"kubeseal-controller"`
-	KubesealControllerNamespace string `envconfig:"KUBESEAL_CONTROLLER_NAMESPACE" default:"kubeseal"`
-	KubesealKeyPrefix           string `envconfig:"KUBESEAL_KEY_PREFIX" default:"sealed-secrets-key"`
-	AWSBucketName               string `envconfig:"AWS_BUCKET_NAME" default:"kubeseal-key-backups" required:"true"`
-	AWSRegion                   string `envconfig:"AWS_REGION" required:"true"`
-	AWSAccessKey                string `envconfig:"AWS_ACCESS_KEY_ID" required:"true"`
-	AWSSecreKey                 string `envconfig:"AWS_SECRET_ACCESS_KEY" required:"true"`
-	Notifier                    string `envconfig:"NOTIFIER" default:"slack"`
-	SlackAPIToken               string `envconfig:"SLACK_API_TOKEN"`
-	SlackChannelName            string `envconfig:"SLACK_CHANNEL_NAME"`
+	KubernetesKubeconfigPath        string            `envconfig:"KUBERNETES_KUBECONFIG_PATH"`
+	KubernetesClientMode            string            `envconfig:"KUBERNETES_CLIENT_MODE" default:"internal"`
+	KubesealControllerName          string            `envconfig:"KUBESEAL_CONTROLLER_NAME" default:"kubeseal-controller"`
+	KubesealControllerNamespace     string            `envconfig:"KUBESEAL_CONTROLLER_NAMESPACE" default:"kubeseal"`
+	KubesealKeyPrefix               string            `envconfig:"KUBESEAL_KEY_PREFIX" default:"sealed-secrets-key"`
+	KubesealPodSelector             string            `envconfig:"KUBESEAL_POD_SELECTOR"`
+	KubesealRestartStrategy         string            `envconfig:"KUBESEAL_RESTART_STRATEGY" default:"delete"`
+	KubesealRolloutTimeout          time.Duration     `envconfig:"KUBESEAL_ROLLOUT_TIMEOUT" default:"5m"`
+	KubesealVerifyRestart           bool              `envconfig:"KUBESEAL_VERIFY_RESTART" default:"false"`
+	ClusterName                     string            `envconfig:"CLUSTER_NAME"`
+	Backend                         string            `envconfig:"BACKEND" default:"s3"`
+	BackendPolicy                   string            `envconfig:"BACKEND_POLICY" default:"all"`
+	KeyNameTemplate                 string            `envconfig:"KEY_NAME_TEMPLATE" default:"{{.Namespace}}/{{.Controller}}/{{.Timestamp}}-{{.SecretName}}.yaml"`
+	BundleNameTemplate              string            `envconfig:"BUNDLE_NAME_TEMPLATE" default:"{{.Namespace}}/{{.Controller}}/{{.Timestamp}}-bundle.yaml"`
+	CatalogName                     string            `envconfig:"CATALOG_NAME" default:"index.json"`
+	DryRun                          bool              `envconfig:"DRY_RUN" default:"false"`
+	Decommission                    bool              `envconfig:"DECOMMISSION" default:"false"`
+	DecommissionPolicy              string            `envconfig:"DECOMMISSION_POLICY" default:"on-new-key"`
+	DecommissionKeepLatest          int               `envconfig:"DECOMMISSION_KEEP_LATEST" default:"1"`
+	DecommissionGracePeriod         time.Duration     `envconfig:"DECOMMISSION_GRACE_PERIOD"`
+	Mode                            string            `envconfig:"MODE" default:"backup"`
+	RestoreKeys                     string            `envconfig:"RESTORE_KEYS" default:"all"`
+	PruneOlderThan                  time.Duration     `envconfig:"PRUNE_OLDER_THAN"`
+	PruneFingerprints               string            `envconfig:"PRUNE_FINGERPRINTS"`
+	WatchResync                     time.Duration     `envconfig:"WATCH_RESYNC" default:"10m"`
+	AWSBucketName                   string            `envconfig:"AWS_BUCKET_NAME" default:"kubeseal-key-backups"`
+	AWSRegion                       string            `envconfig:"AWS_REGION"`
+	AWSAccessKey                    string            `envconfig:"AWS_ACCESS_KEY_ID"`
+	AWSSecreKey                     string            `envconfig:"AWS_SECRET_ACCESS_KEY"`
+	AWSEndpoint                     string            `envconfig:"AWS_ENDPOINT"`
+	AWSS3ForcePathStyle             bool              `envconfig:"AWS_S3_FORCE_PATH_STYLE" default:"false"`
+	AWSDisableSSL                   bool              `envconfig:"AWS_DISABLE_SSL" default:"false"`
+	AWSCABundle                     string            `envconfig:"AWS_CA_BUNDLE"`
+	AWSSSE                          string            `envconfig:"AWS_SSE"`
+	AWSSSEKMSKeyID                  string            `envconfig:"AWS_SSE_KMS_KEY_ID"`
+	AWSSSECustomerKeyFile           string            `envconfig:"AWS_SSE_CUSTOMER_KEY_FILE"`
+	AWSStorageClass                 string            `envconfig:"AWS_STORAGE_CLASS"`
+	AWSObjectTags                   map[string]string `envconfig:"AWS_OBJECT_TAGS"`
+	AWSObjectLockMode               string            `envconfig:"AWS_OBJECT_LOCK_MODE"`
+	AWSObjectLockRetention          time.Duration     `envconfig:"AWS_OBJECT_LOCK_RETENTION"`
+	AWSObjectLockRetainUntil        string            `envconfig:"AWS_OBJECT_LOCK_RETAIN_UNTIL"`
+	FilesystemPath                  string            `envconfig:"FILESYSTEM_PATH" default:"/backups"`
+	GCSBucketName                   string            `envconfig:"GCS_BUCKET_NAME"`
+	GCSPrefix                       string            `envconfig:"GCS_PREFIX"`
+	GCSEndpoint                     string            `envconfig:"GCS_ENDPOINT"`
+	GCSCredentialsFile              string            `envconfig:"GCS_CREDENTIALS_FILE"`
+	GCSAnonymous                    bool              `envconfig:"GCS_ANONYMOUS" default:"false"`
+	GCSKMSKeyName                   string            `envconfig:"GCS_KMS_KEY_NAME"`
+	GCSMetadata                     map[string]string `envconfig:"GCS_METADATA"`
+	AzureStorageAccount             string            `envconfig:"AZURE_STORAGE_ACCOUNT"`
+	AzureStorageKey                 string            `envconfig:"AZURE_STORAGE_KEY"`
+	AzureSASToken                   string            `envconfig:"AZURE_STORAGE_SAS_TOKEN"`
+	AzureContainerName              string            `envconfig:"AZURE_CONTAINER_NAME"`
+	AzurePrefix                     string            `envconfig:"AZURE_PREFIX"`
+	AzureEndpoint                   string            `envconfig:"AZURE_ENDPOINT"`
+	AzureAccessTier                 string            `envconfig:"AZURE_ACCESS_TIER"`
+	VaultAddress                    string            `envconfig:"VAULT_ADDR"`
+	VaultNamespace                  string            `envconfig:"VAULT_NAMESPACE"`
+	VaultCACert                     string            `envconfig:"VAULT_CACERT"`
+	VaultAuthMethod                 string            `envconfig:"VAULT_AUTH_METHOD" default:"token"`
+	VaultAuthMount                  string            `envconfig:"VAULT_AUTH_MOUNT"`
+	VaultToken                      string            `envconfig:"VAULT_TOKEN"`
+	VaultRoleID                     string            `envconfig:"VAULT_ROLE_ID"`
+	VaultSecretID                   string            `envconfig:"VAULT_SECRET_ID"`
+	VaultKubernetesRole             string            `envconfig:"VAULT_KUBERNETES_ROLE"`
+	VaultKubernetesTokenPath        string            `envconfig:"VAULT_KUBERNETES_TOKEN_PATH" default:"/var/run/secrets/kubernetes.io/serviceaccount/token"`
+	VaultKVMount                    string            `envconfig:"VAULT_KV_MOUNT" default:"secret"`
+	VaultKVPath                     string            `envconfig:"VAULT_KV_PATH" default:"kubeseal"`
+	GitURL                          string            `envconfig:"GIT_URL"`
+	GitBranch                       string            `envconfig:"GIT_BRANCH" default:"master"`
+	GitPath                         string            `envconfig:"GIT_PATH"`
+	GitAuthorName                   string            `envconfig:"GIT_AUTHOR_NAME" default:"kubeseal-backuper"`
+	GitAuthorEmail                  string            `envconfig:"GIT_AUTHOR_EMAIL" default:"kubeseal-backuper@localhost"`
+	GitSSHKeyPath                   string            `envconfig:"GIT_SSH_KEY_PATH"`
+	GitKnownHostsPath               string            `envconfig:"GIT_KNOWN_HOSTS_PATH"`
+	GitUsername                     string            `envconfig:"GIT_USERNAME"`
+	GitPassword                     string            `envconfig:"GIT_PASSWORD"`
+	GitAllowPlaintext               bool              `envconfig:"GIT_ALLOW_PLAINTEXT" default:"false"`
+	Encryption                      string            `envconfig:"ENCRYPTION"`
+	AgeRecipients                   []string          `envconfig:"AGE_RECIPIENTS"`
+	AgeRecipientsFile               string            `envconfig:"AGE_RECIPIENTS_FILE"`
+	AgeIdentityFile                 string            `envconfig:"AGE_IDENTITY_FILE"`
+	PGPPublicKeyringFile            string            `envconfig:"PGP_PUBLIC_KEYRING_FILE"`
+	PGPPublicKeyringSecret          string            `envconfig:"PGP_PUBLIC_KEYRING_SECRET"`
+	PGPPublicKeyringSecretNamespace string            `envconfig:"PGP_PUBLIC_KEYRING_SECRET_NAMESPACE"`
+	PGPPublicKeyringSecretKey       string            `envconfig:"PGP_PUBLIC_KEYRING_SECRET_KEY" default:"pubring.asc"`
+	PGPPrivateKeyringFile           string            `envconfig:"PGP_PRIVATE_KEYRING_FILE"`
+	PGPPassphrase                   string            `envconfig:"PGP_PASSPHRASE"`
+	KMSProvider                     string            `envconfig:"KMS_PROVIDER" default:"aws"`
+	KMSKeyID                        string            `envconfig:"KMS_KEY_ID"`
+	KMSLocalKeyFile                 string            `envconfig:"KMS_LOCAL_KEY_FILE"`
+	Notifier                        string            `envconfig:"NOTIFIER" default:"slack"`
+	SlackAPIToken                   string            `envconfig:"SLACK_API_TOKEN"`
+	SlackChannelName                string            `envconfig:"SLACK_CHANNEL_NAME"`
 }
 type State struct {
 	K8s         *kube.KuberneteClient
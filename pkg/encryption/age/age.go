@@ -0,0 +1,96 @@
+package age
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+	"github.com/rayanebel/kubeseal-backuper/pkg/encryption"
+)
+
+// Encrypter - age implementation of encryption.Encrypter.
+type Encrypter struct {
+	Recipients []age.Recipient
+	Identities []age.Identity
+}
+
+func init() {
+	encryption.Register("age", ".age", NewEncrypter)
+}
+
+// New - Init an age encrypter. Recipients are age public keys, identities are only needed to decrypt.
+func New(recipients []string, identities []age.Identity) (*Encrypter, error) {
+	e := &Encrypter{Identities: identities}
+	for _, r := range recipients {
+		r = strings.TrimSpace(r)
+		if r == "" || strings.HasPrefix(r, "#") {
+			continue
+		}
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid age recipient %s: %s", r, err.Error())
+		}
+		e.Recipients = append(e.Recipients, recipient)
+	}
+	return e, nil
+}
+
+// NewEncrypter - Init the age encrypter from the current state.
+func NewEncrypter(state *config.State) (encryption.Encrypter, error) {
+	recipients := state.Config.AgeRecipients
+	if state.Config.AgeRecipientsFile != "" {
+		lines, err := readLines(state.Config.AgeRecipientsFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read age recipients file: %s", err.Error())
+		}
+		recipients = append(recipients, lines...)
+	}
+
+	var identities []age.Identity
+	if state.Config.AgeIdentityFile != "" {
+		file, err := os.Open(state.Config.AgeIdentityFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open age identity file: %s", err.Error())
+		}
+		defer file.Close()
+		identities, err = age.ParseIdentities(file)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse age identity file: %s", err.Error())
+		}
+	}
+	return New(recipients, identities)
+}
+
+// Encrypt - Encrypt to every recipient.
+func (e *Encrypter) Encrypt(dst io.Writer) (io.WriteCloser, error) {
+	if len(e.Recipients) == 0 {
+		return nil, fmt.Errorf("No age recipient has been provided")
+	}
+	return age.Encrypt(dst, e.Recipients...)
+}
+
+// Decrypt - Decrypt with the configured identities.
+func (e *Encrypter) Decrypt(src io.Reader) (io.Reader, error) {
+	if len(e.Identities) == 0 {
+		return nil, fmt.Errorf("No age identity has been provided")
+	}
+	return age.Decrypt(src, e.Identities...)
+}
+
+func readLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,141 @@
+package age
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+)
+
+// writeIdentity - Generate an X25519 identity, write it into dir and return its path and public key.
+func writeIdentity(t *testing.T, dir string, name string) (string, string) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	content := "# created: 2021-01-01T00:00:00Z\n" + identity.String() + "\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, identity.Recipient().String()
+}
+
+func encrypt(t *testing.T, conf *config.Config, plaintext string) []byte {
+	enc, err := NewEncrypter(&config.State{Config: conf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sealed bytes.Buffer
+	writer, err := enc.Encrypt(&sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write([]byte(plaintext))
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	identityPath, recipient := writeIdentity(t, dir, "identity.txt")
+	otherPath, other := writeIdentity(t, dir, "other.txt")
+	recipientsPath := filepath.Join(dir, "recipients.txt")
+	if err := ioutil.WriteFile(recipientsPath, []byte("# backup operators\n\n"+other+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := "apiVersion: v1\nkind: Secret\n"
+	sealed := encrypt(t, &config.Config{AgeRecipients: []string{recipient}, AgeRecipientsFile: recipientsPath}, plaintext)
+	if bytes.Contains(sealed, []byte("kind: Secret")) {
+		t.Fatalf("message is not encrypted: %q", sealed)
+	}
+
+	// Each recipient can decrypt with its own identity.
+	for _, path := range []string{identityPath, otherPath} {
+		enc, err := NewEncrypter(&config.State{Config: &config.Config{AgeIdentityFile: path}})
+		if err != nil {
+			t.Fatalf("NewEncrypter: %v", err)
+		}
+		reader, err := enc.Decrypt(bytes.NewReader(sealed))
+		if err != nil {
+			t.Fatalf("Decrypt with %s: %v", filepath.Base(path), err)
+		}
+		got, err := ioutil.ReadAll(reader)
+		if err != nil || string(got) != plaintext {
+			t.Errorf("Decrypt with %s = %q, %v, want %q", filepath.Base(path), got, err, plaintext)
+		}
+	}
+}
+
+func TestDecryptWithWrongIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, recipient := writeIdentity(t, dir, "identity.txt")
+	wrongPath, _ := writeIdentity(t, dir, "wrong.txt")
+
+	sealed := encrypt(t, &config.Config{AgeRecipients: []string{recipient}}, "content")
+	enc, err := NewEncrypter(&config.State{Config: &config.Config{AgeIdentityFile: wrongPath}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enc.Decrypt(bytes.NewReader(sealed)); err == nil {
+		t.Error("Decrypt with a wrong identity succeeded")
+	}
+}
+
+func TestNewEncrypter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, recipient := writeIdentity(t, dir, "identity.txt")
+	invalidPath := filepath.Join(dir, "invalid.txt")
+	if err := ioutil.WriteFile(invalidPath, []byte("not an identity\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		conf    config.Config
+		wantErr bool
+	}{
+		{name: "recipient", conf: config.Config{AgeRecipients: []string{recipient}}},
+		{name: "invalid recipient", conf: config.Config{AgeRecipients: []string{"age1invalid"}}, wantErr: true},
+		{name: "missing recipients file", conf: config.Config{AgeRecipientsFile: filepath.Join(dir, "missing")}, wantErr: true},
+		{name: "missing identity file", conf: config.Config{AgeIdentityFile: filepath.Join(dir, "missing")}, wantErr: true},
+		{name: "invalid identity file", conf: config.Config{AgeIdentityFile: invalidPath}, wantErr: true},
+	}
+	for _, tt := range tests {
+		conf := tt.conf
+		_, err := NewEncrypter(&config.State{Config: &conf})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: NewEncrypter error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	enc, err := New(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enc.Encrypt(&bytes.Buffer{}); err == nil {
+		t.Error("Encrypt without recipient succeeded")
+	}
+	if _, err := enc.Decrypt(&bytes.Buffer{}); err == nil {
+		t.Error("Decrypt without identity succeeded")
+	}
+}
@@ -0,0 +1,89 @@
+package encryption
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+)
+
+// Encrypter - Interface implemented by every client-side encryption provider.
+type Encrypter interface {
+	// Encrypt - Return a writer encrypting everything written to it into dst. Close must be called to flush it.
+	Encrypt(dst io.Writer) (io.WriteCloser, error)
+	// Decrypt - Return a reader of the plaintext of src.
+	Decrypt(src io.Reader) (io.Reader, error)
+}
+
+// Factory - Function used to build an encryption provider from the current state.
+type Factory func(state *config.State) (Encrypter, error)
+
+type provider struct {
+	factory   Factory
+	extension string
+}
+
+var (
+	mu        sync.RWMutex
+	providers = make(map[string]provider)
+)
+
+// Register - Make an encryption provider available under the given name. Objects whose name ends with extension
+// are decrypted with this provider.
+func Register(name, extension string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if factory == nil {
+		panic("encryption: Register factory is nil")
+	}
+	if _, dup := providers[name]; dup {
+		panic("encryption: Register called twice for provider " + name)
+	}
+	providers[name] = provider{factory: factory, extension: extension}
+}
+
+// New - Build the encryption provider registered under the given name.
+func New(name string, state *config.State) (Encrypter, error) {
+	mu.RLock()
+	p, ok := providers[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unsupported encryption provider %s (available: %v)", name, Names())
+	}
+	return p.factory(state)
+}
+
+// Extension - Suffix appended to the name of objects encrypted by the given provider.
+func Extension(name string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return providers[name].extension
+}
+
+// ForObject - Find the name of the provider which encrypted an object, from its extension. It returns an empty
+// string for plaintext objects.
+func ForObject(key string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for name, p := range providers {
+		if strings.HasSuffix(key, p.extension) {
+			return name
+		}
+	}
+	return ""
+}
+
+// Names - List the name of all registered providers.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
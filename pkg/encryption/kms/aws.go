@@ -0,0 +1,65 @@
+package kms
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+)
+
+// AWSKeyWrapper - AWS KMS implementation of KeyWrapper.
+type AWSKeyWrapper struct {
+	Client *kms.KMS
+	KeyId  string
+}
+
+// NewAWSKeyWrapper - Init an AWS KMS key wrapper. It opens its own session: the one of the s3 backend may target an
+// S3 compatible endpoint (AWS_ENDPOINT, path-style, plain HTTP) which must not be used for KMS.
+func NewAWSKeyWrapper(state *config.State) (*AWSKeyWrapper, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(state.Config.AWSRegion),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open session to AWS: %s", err.Error())
+	}
+	return &AWSKeyWrapper{
+		Client: kms.New(sess),
+		KeyId:  state.Config.KMSKeyID,
+	}, nil
+}
+
+// GenerateDataKey - Ask KMS for a new AES-256 data key.
+func (w *AWSKeyWrapper) GenerateDataKey(ctx context.Context, encryptionContext map[string]string) ([]byte, []byte, error) {
+	if w.KeyId == "" {
+		return nil, nil, fmt.Errorf("KMS_KEY_ID is required to encrypt with AWS KMS")
+	}
+	out, err := w.Client.GenerateDataKeyWithContext(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             aws.String(w.KeyId),
+		KeySpec:           aws.String(kms.DataKeySpecAes256),
+		EncryptionContext: aws.StringMap(encryptionContext),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return out.Plaintext, out.CiphertextBlob, nil
+}
+
+// Decrypt - Unwrap a data key, the master key is identified by KMS from the wrapped key itself.
+func (w *AWSKeyWrapper) Decrypt(ctx context.Context, wrapped []byte, encryptionContext map[string]string) ([]byte, error) {
+	out, err := w.Client.DecryptWithContext(ctx, &kms.DecryptInput{
+		CiphertextBlob:    wrapped,
+		EncryptionContext: aws.StringMap(encryptionContext),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+// KeyID - Identifier of the KMS master key.
+func (w *AWSKeyWrapper) KeyID() string {
+	return w.KeyId
+}
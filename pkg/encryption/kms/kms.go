@@ -0,0 +1,178 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+	"github.com/rayanebel/kubeseal-backuper/pkg/encryption"
+)
+
+const envelopeVersion = 1
+
+// KeyWrapper - A key management service able to generate data keys and to unwrap them.
+type KeyWrapper interface {
+	// GenerateDataKey - Return a fresh 256 bits data key in plaintext and wrapped by the master key.
+	GenerateDataKey(ctx context.Context, encryptionContext map[string]string) (plaintext []byte, wrapped []byte, err error)
+	// Decrypt - Unwrap a data key.
+	Decrypt(ctx context.Context, wrapped []byte, encryptionContext map[string]string) ([]byte, error)
+	// KeyID - Identifier of the master key.
+	KeyID() string
+}
+
+// Envelope - Document stored in place of the plaintext: the payload encrypted with a data key, stored alongside the
+// data key wrapped by the KMS.
+type Envelope struct {
+	Version           int               `json:"version"`
+	Provider          string            `json:"provider"`
+	KeyID             string            `json:"keyId"`
+	EncryptionContext map[string]string `json:"encryptionContext,omitempty"`
+	WrappedKey        []byte            `json:"wrappedKey"`
+	Nonce             []byte            `json:"nonce"`
+	Ciphertext        []byte            `json:"ciphertext"`
+}
+
+// Encrypter - Envelope encryption implementation of encryption.Encrypter.
+type Encrypter struct {
+	Provider          string
+	Wrapper           KeyWrapper
+	EncryptionContext map[string]string
+
+	state *config.State
+}
+
+type envelopeWriter struct {
+	encrypter *Encrypter
+	dst       io.Writer
+	buf       bytes.Buffer
+}
+
+func init() {
+	encryption.Register("kms", ".enc", NewEncrypter)
+}
+
+// NewKeyWrapper - Build the key wrapper of the given KMS provider.
+func NewKeyWrapper(provider string, state *config.State) (KeyWrapper, error) {
+	switch provider {
+	case "aws":
+		return NewAWSKeyWrapper(state)
+	case "local":
+		return NewLocalKeyWrapper(state.Config.KMSLocalKeyFile)
+	default:
+		return nil, fmt.Errorf("Unsupported KMS provider %s (available: aws, local)", provider)
+	}
+}
+
+// NewEncrypter - Init the envelope encrypter from the current state.
+func NewEncrypter(state *config.State) (encryption.Encrypter, error) {
+	return &Encrypter{
+		Provider: state.Config.KMSProvider,
+		EncryptionContext: map[string]string{
+			"controller": state.Config.KubesealControllerName,
+			"namespace":  state.Config.KubesealControllerNamespace,
+		},
+		state: state,
+	}, nil
+}
+
+// Encrypt - Buffer the plaintext, the envelope is written to dst when the writer is closed.
+func (e *Encrypter) Encrypt(dst io.Writer) (io.WriteCloser, error) {
+	if e.Wrapper == nil {
+		wrapper, err := NewKeyWrapper(e.Provider, e.state)
+		if err != nil {
+			return nil, err
+		}
+		e.Wrapper = wrapper
+	}
+	return &envelopeWriter{encrypter: e, dst: dst}, nil
+}
+
+// Decrypt - Unwrap the data key with the KMS which wrapped it and decrypt the payload.
+func (e *Encrypter) Decrypt(src io.Reader) (io.Reader, error) {
+	var envelope Envelope
+	err := json.NewDecoder(src).Decode(&envelope)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid envelope: %s", err.Error())
+	}
+	if envelope.Version != envelopeVersion {
+		return nil, fmt.Errorf("Unsupported envelope version %d", envelope.Version)
+	}
+	wrapper, err := NewKeyWrapper(envelope.Provider, e.state)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := wrapper.Decrypt(context.Background(), envelope.WrappedKey, envelope.EncryptionContext)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to unwrap data key with %s: %s", envelope.KeyID, err.Error())
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	header, err := envelope.header()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, envelope.Nonce, envelope.Ciphertext, header)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt payload: %s", err.Error())
+	}
+	return bytes.NewReader(plaintext), nil
+}
+
+func (w *envelopeWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+// Close - Encrypt the buffered plaintext with a fresh data key and write the envelope.
+func (w *envelopeWriter) Close() error {
+	e := w.encrypter
+	dataKey, wrapped, err := e.Wrapper.GenerateDataKey(context.Background(), e.EncryptionContext)
+	if err != nil {
+		return fmt.Errorf("Unable to generate data key with %s: %s", e.Wrapper.KeyID(), err.Error())
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+	envelope := Envelope{
+		Version:           envelopeVersion,
+		Provider:          e.Provider,
+		KeyID:             e.Wrapper.KeyID(),
+		EncryptionContext: e.EncryptionContext,
+		WrappedKey:        wrapped,
+		Nonce:             nonce,
+	}
+	header, err := envelope.header()
+	if err != nil {
+		return err
+	}
+	envelope.Ciphertext = gcm.Seal(nil, nonce, w.buf.Bytes(), header)
+	return json.NewEncoder(w.dst).Encode(envelope)
+}
+
+// header - Serialize every field of the envelope but the ciphertext. It is authenticated along with the payload, so
+// the provider, the key or the version recorded cannot be altered.
+func (e Envelope) header() ([]byte, error) {
+	e.Ciphertext = nil
+	return json.Marshal(e)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,171 @@
+package kms
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+)
+
+// localState - State using the local provider with a master key written into dir.
+func localState(t *testing.T, dir string) *config.State {
+	path := filepath.Join(dir, "master.key")
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{42}, 32))
+	if err := ioutil.WriteFile(path, []byte(key+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return &config.State{Config: &config.Config{
+		KMSProvider:                 "local",
+		KMSLocalKeyFile:             path,
+		KubesealControllerName:      "sealed-secrets",
+		KubesealControllerNamespace: "kube-system",
+	}}
+}
+
+func seal(t *testing.T, state *config.State, plaintext string) []byte {
+	enc, err := NewEncrypter(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sealed bytes.Buffer
+	writer, err := enc.Encrypt(&sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write([]byte(plaintext)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state := localState(t, dir)
+	plaintext := "apiVersion: v1\nkind: Secret\n"
+	sealed := seal(t, state, plaintext)
+	if bytes.Contains(sealed, []byte(plaintext)) {
+		t.Fatal("envelope holds the plaintext")
+	}
+
+	tests := []struct {
+		name    string
+		tamper  func(envelope *Envelope)
+		wantErr bool
+	}{
+		{name: "untouched", tamper: func(envelope *Envelope) {}},
+		{name: "other encryption context", tamper: func(envelope *Envelope) { envelope.EncryptionContext["namespace"] = "default" }, wantErr: true},
+		{name: "other key id", tamper: func(envelope *Envelope) { envelope.KeyID = "local:other" }, wantErr: true},
+		{name: "modified ciphertext", tamper: func(envelope *Envelope) { envelope.Ciphertext[0] ^= 1 }, wantErr: true},
+		{name: "unknown version", tamper: func(envelope *Envelope) { envelope.Version = 2 }, wantErr: true},
+		{name: "unknown provider", tamper: func(envelope *Envelope) { envelope.Provider = "vault" }, wantErr: true},
+	}
+	for _, tt := range tests {
+		var envelope Envelope
+		if err := json.Unmarshal(sealed, &envelope); err != nil {
+			t.Fatal(err)
+		}
+		tt.tamper(&envelope)
+		content, err := json.Marshal(envelope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc, err := NewEncrypter(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, err := enc.Decrypt(bytes.NewReader(content))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Decrypt error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		got, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != plaintext {
+			t.Errorf("%s: Decrypt = %q, want %q", tt.name, got, plaintext)
+		}
+	}
+}
+
+func TestNewLocalKeyWrapper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "valid", content: base64.StdEncoding.EncodeToString(make([]byte, 32))},
+		{name: "short", content: base64.StdEncoding.EncodeToString(make([]byte, 16)), wantErr: true},
+		{name: "not base64", content: "not a key", wantErr: true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		wrapper, err := NewLocalKeyWrapper(path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: NewLocalKeyWrapper error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !strings.HasPrefix(wrapper.KeyID(), "local:") {
+			t.Errorf("%s: KeyID = %s, want a local: prefix", tt.name, wrapper.KeyID())
+		}
+	}
+	if _, err := NewLocalKeyWrapper(filepath.Join(dir, "missing")); err == nil {
+		t.Error("NewLocalKeyWrapper of a missing file succeeded")
+	}
+}
+
+func TestAWSKeyWrapperIgnoresS3Endpoint(t *testing.T) {
+	s3Session, err := session.NewSession(&aws.Config{
+		Region:           aws.String("eu-west-1"),
+		Endpoint:         aws.String("http://minio:9000"),
+		S3ForcePathStyle: aws.Bool(true),
+		DisableSSL:       aws.Bool(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &config.State{
+		Config: &config.Config{
+			AWSRegion:           "eu-west-1",
+			AWSEndpoint:         "http://minio:9000",
+			AWSS3ForcePathStyle: true,
+			AWSDisableSSL:       true,
+			KMSKeyID:            "alias/kubeseal",
+		},
+		AWSClient: s3Session,
+	}
+	wrapper, err := NewAWSKeyWrapper(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://kms.eu-west-1.amazonaws.com"; wrapper.Client.Endpoint != want {
+		t.Errorf("KMS endpoint = %s, want %s", wrapper.Client.Endpoint, want)
+	}
+}
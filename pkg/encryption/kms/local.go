@@ -0,0 +1,97 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// LocalKeyWrapper - KeyWrapper using a master key read from a local file. It is meant for offline tests and
+// air-gapped clusters without a KMS.
+type LocalKeyWrapper struct {
+	MasterKey []byte
+	keyID     string
+}
+
+// NewLocalKeyWrapper - Init a local key wrapper from a file holding a base64 encoded 256 bits key, as generated by
+// `openssl rand -base64 32`.
+func NewLocalKeyWrapper(path string) (*LocalKeyWrapper, error) {
+	if path == "" {
+		return nil, fmt.Errorf("KMS_LOCAL_KEY_FILE is required by the local KMS provider")
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read local master key: %s", err.Error())
+	}
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(content)))
+	if err != nil {
+		return nil, fmt.Errorf("Local master key must be base64 encoded: %s", err.Error())
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("Local master key must be 32 bytes long, got %d", len(key))
+	}
+	sum := sha256.Sum256(key)
+	return &LocalKeyWrapper{
+		MasterKey: key,
+		keyID:     "local:" + hex.EncodeToString(sum[:8]),
+	}, nil
+}
+
+// GenerateDataKey - Generate a random data key and wrap it with AES-256-GCM. The encryption context is used as
+// additional authenticated data.
+func (w *LocalKeyWrapper) GenerateDataKey(ctx context.Context, encryptionContext map[string]string) ([]byte, []byte, error) {
+	dataKey := make([]byte, 32)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := newGCM(w.MasterKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped := gcm.Seal(nonce, nonce, dataKey, additionalData(encryptionContext))
+	return dataKey, wrapped, nil
+}
+
+// Decrypt - Unwrap a data key with the master key.
+func (w *LocalKeyWrapper) Decrypt(ctx context.Context, wrapped []byte, encryptionContext map[string]string) ([]byte, error) {
+	gcm, err := newGCM(w.MasterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, fmt.Errorf("Wrapped key is too short")
+	}
+	nonce, ciphertext := wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData(encryptionContext))
+}
+
+// KeyID - Identifier derived from the master key.
+func (w *LocalKeyWrapper) KeyID() string {
+	return w.keyID
+}
+
+// additionalData - Serialize the encryption context in a stable order.
+func additionalData(encryptionContext map[string]string) []byte {
+	keys := make([]string, 0, len(encryptionContext))
+	for k := range encryptionContext {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "%s=%s\n", k, encryptionContext[k])
+	}
+	return buf.Bytes()
+}
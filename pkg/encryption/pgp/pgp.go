@@ -0,0 +1,172 @@
+package pgp
+
+import (
+	"bytes"
+	// Register the hash functions used by OpenPGP signatures and key preferences.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+	"github.com/rayanebel/kubeseal-backuper/pkg/encryption"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	// Keys without hash preferences default to RIPEMD160.
+	_ "golang.org/x/crypto/ripemd160"
+)
+
+const messageType = "PGP MESSAGE"
+
+// Encrypter - OpenPGP implementation of encryption.Encrypter producing ASCII-armored messages.
+type Encrypter struct {
+	Recipients openpgp.EntityList
+	// PrivateKeys - Keyring used to decrypt, only needed during a restore.
+	PrivateKeys openpgp.EntityList
+	Passphrase  []byte
+
+	state *config.State
+}
+
+type armoredWriter struct {
+	plaintext io.WriteCloser
+	armor     io.WriteCloser
+}
+
+func init() {
+	encryption.Register("pgp", ".asc", NewEncrypter)
+}
+
+// ReadKeyRing - Parse an armored or binary OpenPGP keyring.
+func ReadKeyRing(content []byte) (openpgp.EntityList, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(content))
+	if err == nil {
+		return entities, nil
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(content))
+}
+
+// NewEncrypter - Init the OpenPGP encrypter from the current state. The private keyring is read right away, the public
+// keys are only loaded on the first encryption, so that decrypting does not require them.
+func NewEncrypter(state *config.State) (encryption.Encrypter, error) {
+	e := &Encrypter{
+		Passphrase: []byte(state.Config.PGPPassphrase),
+		state:      state,
+	}
+	if state.Config.PGPPrivateKeyringFile != "" {
+		privateKeyring, err := ioutil.ReadFile(state.Config.PGPPrivateKeyringFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read private keyring: %s", err.Error())
+		}
+		e.PrivateKeys, err = ReadKeyRing(privateKeyring)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse private keyring: %s", err.Error())
+		}
+	}
+	return e, nil
+}
+
+// publicKeyring - Read the public keyring from a keyring file or from a Kubernetes secret, nil when none is configured.
+func publicKeyring(state *config.State) ([]byte, error) {
+	switch {
+	case state.Config.PGPPublicKeyringFile != "":
+		content, err := ioutil.ReadFile(state.Config.PGPPublicKeyringFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read public keyring: %s", err.Error())
+		}
+		return content, nil
+	case state.Config.PGPPublicKeyringSecret != "":
+		if state.K8s == nil {
+			return nil, fmt.Errorf("A kubernetes client is required to read the public keyring secret")
+		}
+		namespace := state.Config.PGPPublicKeyringSecretNamespace
+		if namespace == "" {
+			namespace = state.Config.KubesealControllerNamespace
+		}
+		secret, err := state.K8s.GetSecret(namespace, state.Config.PGPPublicKeyringSecret)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get public keyring secret %s/%s: %s", namespace, state.Config.PGPPublicKeyringSecret, err.Error())
+		}
+		content, ok := secret.Data[state.Config.PGPPublicKeyringSecretKey]
+		if !ok {
+			return nil, fmt.Errorf("Secret %s/%s has no %s entry", namespace, secret.Name, state.Config.PGPPublicKeyringSecretKey)
+		}
+		return content, nil
+	default:
+		return nil, nil
+	}
+}
+
+// Encrypt - Encrypt to every public key of the keyring and armor the result. The keyring is loaded on the first call.
+func (e *Encrypter) Encrypt(dst io.Writer) (io.WriteCloser, error) {
+	if len(e.Recipients) == 0 && e.state != nil {
+		keyring, err := publicKeyring(e.state)
+		if err != nil {
+			return nil, err
+		}
+		if keyring != nil {
+			e.Recipients, err = ReadKeyRing(keyring)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to parse public keyring: %s", err.Error())
+			}
+		}
+	}
+	if len(e.Recipients) == 0 {
+		return nil, fmt.Errorf("No OpenPGP public key has been provided")
+	}
+	armored, err := armor.Encode(dst, messageType, nil)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := openpgp.Encrypt(armored, e.Recipients, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &armoredWriter{plaintext: plaintext, armor: armored}, nil
+}
+
+// Decrypt - Decrypt an armored message with the private keyring, unlocking the keys with the passphrase.
+func (e *Encrypter) Decrypt(src io.Reader) (io.Reader, error) {
+	if len(e.PrivateKeys) == 0 {
+		return nil, fmt.Errorf("No OpenPGP private key has been provided")
+	}
+	block, err := armor.Decode(src)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type != messageType {
+		return nil, fmt.Errorf("Unexpected armor type %s", block.Type)
+	}
+	tried := false
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if tried || symmetric {
+			return nil, fmt.Errorf("Unable to decrypt private key with the passphrase provided")
+		}
+		tried = true
+		for _, key := range keys {
+			if key.PrivateKey != nil && key.PrivateKey.Encrypted {
+				key.PrivateKey.Decrypt(e.Passphrase)
+			}
+		}
+		return nil, nil
+	}
+	md, err := openpgp.ReadMessage(block.Body, e.PrivateKeys, prompt, nil)
+	if err != nil {
+		return nil, err
+	}
+	return md.UnverifiedBody, nil
+}
+
+func (w *armoredWriter) Write(p []byte) (int, error) {
+	return w.plaintext.Write(p)
+}
+
+// Close - Flush the OpenPGP message then the armor.
+func (w *armoredWriter) Close() error {
+	err := w.plaintext.Close()
+	if err != nil {
+		return err
+	}
+	return w.armor.Close()
+}
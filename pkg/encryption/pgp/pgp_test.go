@@ -0,0 +1,120 @@
+package pgp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// writeKeyrings - Generate a key pair and write its public and private keyrings into dir.
+func writeKeyrings(t *testing.T, dir string) (string, string) {
+	entity, err := openpgp.NewEntity("backup", "", "backup@localhost", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var public, private bytes.Buffer
+	w, err := armor.Encode(&public, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if err := entity.SerializePrivate(&private, nil); err != nil {
+		t.Fatal(err)
+	}
+	publicPath, privatePath := filepath.Join(dir, "public.asc"), filepath.Join(dir, "private.gpg")
+	for path, content := range map[string][]byte{publicPath: public.Bytes(), privatePath: private.Bytes()} {
+		if err := ioutil.WriteFile(path, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return publicPath, privatePath
+}
+
+func encrypt(t *testing.T, state *config.State, plaintext string) ([]byte, error) {
+	enc, err := NewEncrypter(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sealed bytes.Buffer
+	writer, err := enc.Encrypt(&sealed)
+	if err != nil {
+		return nil, err
+	}
+	writer.Write([]byte(plaintext))
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes(), nil
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pgp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	publicPath, privatePath := writeKeyrings(t, dir)
+
+	plaintext := "apiVersion: v1\nkind: Secret\n"
+	sealed, err := encrypt(t, &config.State{Config: &config.Config{PGPPublicKeyringFile: publicPath}}, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(sealed), "-----BEGIN PGP MESSAGE-----") {
+		t.Fatalf("message is not armored: %q", sealed)
+	}
+
+	// The public keyring secret cannot be read without a kubernetes client, which decrypting must not need.
+	enc, err := NewEncrypter(&config.State{Config: &config.Config{
+		PGPPublicKeyringSecret: "pgp-public-keys",
+		PGPPrivateKeyringFile:  privatePath,
+	}})
+	if err != nil {
+		t.Fatalf("NewEncrypter: %v", err)
+	}
+	reader, err := enc.Decrypt(bytes.NewReader(sealed))
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	got, err := ioutil.ReadAll(reader)
+	if err != nil || string(got) != plaintext {
+		t.Errorf("Decrypt = %q, %v, want %q", got, err, plaintext)
+	}
+}
+
+func TestEncryptLoadsPublicKeyring(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pgp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	publicPath, _ := writeKeyrings(t, dir)
+
+	tests := []struct {
+		name    string
+		conf    config.Config
+		wantErr bool
+	}{
+		{name: "keyring file", conf: config.Config{PGPPublicKeyringFile: publicPath}},
+		{name: "missing keyring file", conf: config.Config{PGPPublicKeyringFile: filepath.Join(dir, "missing")}, wantErr: true},
+		{name: "keyring secret without client", conf: config.Config{PGPPublicKeyringSecret: "pgp-public-keys"}, wantErr: true},
+		{name: "no keyring", conf: config.Config{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		conf := tt.conf
+		_, err := encrypt(t, &config.State{Config: &conf}, "content")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Encrypt error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
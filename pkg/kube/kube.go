@@ -4,15 +4,21 @@ import (
 	"fmt"
 
 	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 type KuberneteClient struct {
 	Client *kubernetes.Clientset
+	// DryRun - Log mutating calls instead of sending them to the API server.
+	DryRun bool
 }
 
 // NewOutKubernetesClient - To init an external k8s client
@@ -58,6 +64,20 @@ func (s *KuberneteClient) ListSecrets(namespace string, opts metav1.ListOptions)
 	return secrets, nil
 }
 
+// SearchSecrets - To list k8s secrets by label, finding none is not an error
+func (s *KuberneteClient) SearchSecrets(namespace string, opts metav1.ListOptions) (*v1.SecretList, error) {
+	return s.Client.CoreV1().Secrets(namespace).List(opts)
+}
+
+// GetSecret - To get a k8s secret by name
+func (s *KuberneteClient) GetSecret(namespace string, name string) (*v1.Secret, error) {
+	secret, err := s.Client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
 // ListPods - To list all k8s pods
 func (s *KuberneteClient) ListPods(namespace string, opts metav1.ListOptions) (*v1.PodList, error) {
 	pods, err := s.Client.CoreV1().Pods(namespace).List(opts)
@@ -75,6 +95,14 @@ func (s *KuberneteClient) ListPods(namespace string, opts metav1.ListOptions) (*
 
 // UpdateSecret - To update a given secret
 func (s *KuberneteClient) UpdateSecret(updatedSecret *v1.Secret) error {
+	if s.DryRun {
+		log.WithFields(log.Fields{
+			"secret":    updatedSecret.Name,
+			"namespace": updatedSecret.Namespace,
+			"labels":    updatedSecret.Labels,
+		}).Warning("Dry run: secret would be updated")
+		return nil
+	}
 	_, err := s.Client.CoreV1().Secrets(updatedSecret.Namespace).Update(updatedSecret)
 	if err != nil {
 		return fmt.Errorf("Unable to update secrets: %s", err.Error())
@@ -82,9 +110,32 @@ func (s *KuberneteClient) UpdateSecret(updatedSecret *v1.Secret) error {
 	return nil
 }
 
+// CreateSecret - To create a given secret
+func (s *KuberneteClient) CreateSecret(secret *v1.Secret) (*v1.Secret, error) {
+	if s.DryRun {
+		log.WithFields(log.Fields{
+			"secret":    secret.Name,
+			"namespace": secret.Namespace,
+			"labels":    secret.Labels,
+		}).Warning("Dry run: secret would be created")
+		return secret, nil
+	}
+	created, err := s.Client.CoreV1().Secrets(secret.Namespace).Create(secret)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create secret: %s", err.Error())
+	}
+	return created, nil
+}
+
 // DeletePods - To delete a list of pods
 func (s *KuberneteClient) DeletePods(pods *v1.PodList) error {
 	for _, pod := range pods.Items {
+		if s.DryRun {
+			log.WithFields(log.Fields{
+				"podName": pod.Name,
+			}).Warning("Dry run: pod would be deleted")
+			continue
+		}
 		log.WithFields(log.Fields{
 			"podName": pod.Name,
 		}).Warning("Trying to delete pod")
@@ -100,3 +151,69 @@ func (s *KuberneteClient) DeletePods(pods *v1.PodList) error {
 	return nil
 
 }
+
+// GetDeployment - To get a k8s deployment by name
+func (s *KuberneteClient) GetDeployment(namespace string, name string) (*appsv1.Deployment, error) {
+	deployment, err := s.Client.AppsV1().Deployments(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return deployment, nil
+}
+
+// PatchDeployment - To apply a strategic merge patch to a deployment
+func (s *KuberneteClient) PatchDeployment(namespace string, name string, patch []byte) (*appsv1.Deployment, error) {
+	if s.DryRun {
+		log.WithFields(log.Fields{
+			"deployment": name,
+			"namespace":  namespace,
+			"patch":      string(patch),
+		}).Warning("Dry run: deployment would be patched")
+		return s.GetDeployment(namespace, name)
+	}
+	deployment, err := s.Client.AppsV1().Deployments(namespace).Patch(name, types.StrategicMergePatchType, patch)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to patch deployment: %s", err.Error())
+	}
+	return deployment, nil
+}
+
+// ProxyGetService - To send a GET request to a service through the API server proxy
+func (s *KuberneteClient) ProxyGetService(namespace string, scheme string, name string, port string, path string) ([]byte, error) {
+	body, err := s.Client.CoreV1().Services(namespace).ProxyGet(scheme, name, port, path, nil).DoRaw()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get %s from service %s: %s", path, name, err.Error())
+	}
+	return body, nil
+}
+
+// WatchSecrets - To call handler with every secret matching the label selector when it is added, old being nil, or
+// updated, until stop is closed. It returns once the secrets have been listed.
+func (s *KuberneteClient) WatchSecrets(namespace string, labelSelector string, handler func(old *v1.Secret, secret *v1.Secret), stop <-chan struct{}) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(s.Client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = labelSelector
+		}))
+	informer := factory.Core().V1().Secrets().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if secret, ok := obj.(*v1.Secret); ok {
+				handler(nil, secret)
+			}
+		},
+		UpdateFunc: func(oldObj, obj interface{}) {
+			old, _ := oldObj.(*v1.Secret)
+			if secret, ok := obj.(*v1.Secret); ok {
+				handler(old, secret)
+			}
+		},
+	})
+	factory.Start(stop)
+	for _, synced := range factory.WaitForCacheSync(stop) {
+		if !synced {
+			return fmt.Errorf("Unable to list secrets with labels %s in namespace %s", labelSelector, namespace)
+		}
+	}
+	return nil
+}
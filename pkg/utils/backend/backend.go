@@ -0,0 +1,422 @@
+package backendutils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend/dryrun"
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend/multi"
+	"github.com/rayanebel/kubeseal-backuper/pkg/catalog"
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+	"github.com/rayanebel/kubeseal-backuper/pkg/encryption"
+	encryptionutils "github.com/rayanebel/kubeseal-backuper/pkg/utils/encryption"
+	"github.com/rayanebel/kubeseal-backuper/pkg/utils/kubeseal"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+
+	// Storage backends available through the BACKEND setting.
+	_ "github.com/rayanebel/kubeseal-backuper/pkg/backend/azure"
+	_ "github.com/rayanebel/kubeseal-backuper/pkg/backend/filesystem"
+	_ "github.com/rayanebel/kubeseal-backuper/pkg/backend/gcs"
+	_ "github.com/rayanebel/kubeseal-backuper/pkg/backend/git"
+	_ "github.com/rayanebel/kubeseal-backuper/pkg/backend/s3"
+	_ "github.com/rayanebel/kubeseal-backuper/pkg/backend/vault"
+)
+
+// InitBackend - Utils to init the storage backends selected by the configuration. BACKEND holds a comma separated
+// list of backends, each of them written as <type> or <type>:<name>. Named backends read their settings from
+// environment variables prefixed with the upper-cased name (e.g. DR_AWS_REGION for s3:dr) and fall back to the
+// unprefixed ones.
+func InitBackend(state *config.State) backend.Backend {
+	b := initStorage(state)
+	if state.Config.DryRun {
+		log.WithFields(log.Fields{
+			"backend": state.Config.Backend,
+		}).Warning("Dry run enabled, nothing will be written to the backend")
+		return dryrun.New(b)
+	}
+	return b
+}
+
+// CloseBackend - Utils to release the resources of the storage backend, such as the working copy of the git backend.
+func CloseBackend(b backend.Backend) {
+	err := backend.Close(b)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Warning("Unable to close storage backend")
+	}
+}
+
+// initStorage - Init the storage backends listed in BACKEND.
+func initStorage(state *config.State) backend.Backend {
+	entries := strings.Split(state.Config.Backend, ",")
+	if len(entries) == 1 && !strings.Contains(entries[0], ":") {
+		b, err := backend.New(state.Config.Backend, state)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":   err.Error(),
+				"backend": state.Config.Backend,
+			}).Error("Unable to init storage backend")
+			os.Exit(1)
+		}
+		return b
+	}
+
+	var targets []multi.Target
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		kind, name := entry, ""
+		if i := strings.Index(entry, ":"); i >= 0 {
+			kind, name = entry[:i], entry[i+1:]
+		}
+		instance := state
+		if name != "" {
+			instance = namedState(state, name)
+		}
+		b, err := backend.New(kind, instance)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":   err.Error(),
+				"backend": entry,
+			}).Warning("Unable to init storage backend")
+			b = &multi.Unavailable{Err: err}
+		}
+		targets = append(targets, multi.Target{
+			Name:    entry,
+			Backend: b,
+		})
+	}
+	b, err := multi.New(targets, state.Config.BackendPolicy)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err.Error(),
+			"policy": state.Config.BackendPolicy,
+		}).Error("Unable to init storage backends")
+		os.Exit(1)
+	}
+	log.WithFields(log.Fields{
+		"backends": state.Config.Backend,
+		"policy":   state.Config.BackendPolicy,
+	}).Info("Backups will be uploaded to multiple backends")
+	return b
+}
+
+// namedState - Copy the state with a configuration loaded from the environment variables prefixed by name.
+func namedState(state *config.State, name string) *config.State {
+	prefix := strings.ToUpper(strings.Replace(name, "-", "_", -1))
+	conf := &config.Config{}
+	err := envconfig.Process(prefix, conf)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err.Error(),
+			"prefix": prefix,
+		}).Error("Config error")
+		os.Exit(1)
+	}
+	// Keys are encrypted once for every backend, the -encryption flag only updates the unprefixed settings.
+	conf.Encryption = state.Config.Encryption
+	instance := *state
+	instance.Config = conf
+	instance.AWSClient = nil
+	return &instance
+}
+
+// KeyNameData - Fields available in the KEY_NAME_TEMPLATE and BUNDLE_NAME_TEMPLATE.
+type KeyNameData struct {
+	Cluster     string
+	Namespace   string
+	Controller  string
+	SecretName  string
+	Fingerprint string
+	// Timestamp - Creation date of the key secret formatted as 20060102T150405Z.
+	Timestamp string
+	Time      time.Time
+}
+
+// KeyName - Utils to compute the object name used to store a kubeseal key from a name template such as
+// KEY_NAME_TEMPLATE.
+func KeyName(state *config.State, nameTemplate string, secret v1.Secret, created time.Time, fingerprint string) (string, error) {
+	tmpl, err := template.New("key-name").Parse(nameTemplate)
+	if err != nil {
+		return "", fmt.Errorf("Invalid name template: %s", err.Error())
+	}
+	var name bytes.Buffer
+	err = tmpl.Execute(&name, KeyNameData{
+		Cluster:     state.Config.ClusterName,
+		Namespace:   state.Config.KubesealControllerNamespace,
+		Controller:  state.Config.KubesealControllerName,
+		SecretName:  secret.Name,
+		Fingerprint: fingerprint,
+		Timestamp:   created.UTC().Format("20060102T150405Z"),
+		Time:        created.UTC(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Unable to render name template: %s", err.Error())
+	}
+	key := strings.TrimPrefix(name.String(), "/")
+	if key == "" {
+		return "", fmt.Errorf("Name template rendered an empty object name")
+	}
+	return key, nil
+}
+
+// keyMetadata - Metadata attached to the object holding secret.
+func keyMetadata(state *config.State, enc encryption.Encrypter, secret v1.Secret) map[string]string {
+	metadata := map[string]string{
+		"controller": state.Config.KubesealControllerName,
+		"namespace":  state.Config.KubesealControllerNamespace,
+		"secret":     secret.Name,
+	}
+	if state.Config.ClusterName != "" {
+		metadata["cluster"] = state.Config.ClusterName
+	}
+	fingerprint, err := kubeseal.CertificateFingerprint(secret)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err.Error(),
+			"secret": secret.Name,
+		}).Warning("Unable to compute certificate fingerprint")
+	} else {
+		metadata["fingerprint"] = fingerprint
+	}
+	if enc != nil {
+		metadata["encryption"] = state.Config.Encryption
+	}
+	return metadata
+}
+
+// StoreSecretKey - Utils to store kubeseal key into the configured backend. created is the creation date of the key
+// secret, as it is cleaned from the exported manifest. The upload is skipped when the object already exists, so
+// every key is written once. It returns the catalog entry describing the backup.
+func StoreSecretKey(ctx context.Context, state *config.State, b backend.Backend, enc encryption.Encrypter, secret v1.Secret, created time.Time, payload io.Reader) catalog.Entry {
+	metadata := keyMetadata(state, enc, secret)
+	keyName, err := KeyName(state, state.Config.KeyNameTemplate, secret, created, metadata["fingerprint"])
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err.Error(),
+			"secret": secret.Name,
+		}).Error("Unable to compute object name")
+		os.Exit(1)
+	}
+	entry := catalog.Entry{
+		Key:         keyName + encryptionutils.Extension(state),
+		SecretName:  secret.Name,
+		Namespace:   state.Config.KubesealControllerNamespace,
+		Controller:  state.Config.KubesealControllerName,
+		Cluster:     state.Config.ClusterName,
+		Fingerprint: metadata["fingerprint"],
+		CreatedAt:   created.UTC(),
+		Encryption:  metadata["encryption"],
+	}
+	cert, err := kubeseal.ParseCertificate(secret)
+	if err == nil {
+		entry.NotBefore = cert.NotBefore.UTC()
+		entry.NotAfter = cert.NotAfter.UTC()
+	}
+	content := storeObject(ctx, state, b, enc, keyName, metadata, payload)
+	if content != nil {
+		entry.Checksum = catalog.Checksum(content)
+		entry.Size = int64(len(content))
+		entry.BackedUpAt = time.Now().UTC()
+	}
+	return entry
+}
+
+// StoreBundle - Utils to store the multi-document manifest holding every kubeseal key. The bundle is named after the
+// newest key, so a new bundle is written each time a key is added. It returns the catalog entry describing the bundle.
+func StoreBundle(ctx context.Context, state *config.State, b backend.Backend, enc encryption.Encrypter, secrets []v1.Secret, created time.Time, payload io.Reader) catalog.Bundle {
+	latest := secrets[len(secrets)-1]
+	metadata := keyMetadata(state, enc, latest)
+	names := make([]string, 0, len(secrets))
+	var fingerprints []string
+	for _, secret := range secrets {
+		names = append(names, secret.Name)
+		if fingerprint, err := kubeseal.CertificateFingerprint(secret); err == nil {
+			fingerprints = append(fingerprints, fingerprint)
+		}
+	}
+	metadata["secrets"] = strings.Join(names, ",")
+	keyName, err := KeyName(state, state.Config.BundleNameTemplate, latest, created, metadata["fingerprint"])
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err.Error(),
+			"secret": latest.Name,
+		}).Error("Unable to compute bundle object name")
+		os.Exit(1)
+	}
+	bundle := catalog.Bundle{
+		Key:          keyName + encryptionutils.Extension(state),
+		Namespace:    state.Config.KubesealControllerNamespace,
+		Controller:   state.Config.KubesealControllerName,
+		Cluster:      state.Config.ClusterName,
+		Fingerprints: fingerprints,
+	}
+	if storeObject(ctx, state, b, enc, keyName, metadata, payload) != nil {
+		bundle.BackedUpAt = time.Now().UTC()
+	}
+	return bundle
+}
+
+// storeObject - Encrypt and upload payload unless keyName already exists in the backend. It returns the uploaded
+// content, nil when the upload has been skipped.
+func storeObject(ctx context.Context, state *config.State, b backend.Backend, enc encryption.Encrypter, keyName string, metadata map[string]string, payload io.Reader) []byte {
+	keyName += encryptionutils.Extension(state)
+	_, err := b.Stat(ctx, keyName)
+	if err == nil {
+		log.WithFields(log.Fields{
+			"filename": keyName,
+			"backend":  state.Config.Backend,
+		}).Info("Key has already been backed up, skipping upload")
+		replicate(ctx, state, b, keyName, metadata)
+		return nil
+	}
+	if err != backend.ErrNotFound {
+		log.WithFields(log.Fields{
+			"error":    err.Error(),
+			"filename": keyName,
+		}).Warning("Unable to check if the key has already been backed up")
+	}
+
+	encrypted, err := encryptionutils.EncryptPayload(enc, payload)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":      err.Error(),
+			"encryption": state.Config.Encryption,
+		}).Error("Unable to encrypt kubeseal key")
+		os.Exit(1)
+	}
+	content, err := ioutil.ReadAll(encrypted)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":    err.Error(),
+			"filename": keyName,
+		}).Error("Unable to read kubeseal key")
+		os.Exit(1)
+	}
+	err = b.Put(ctx, keyName, bytes.NewReader(content), metadata)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err.Error(),
+			"backend": state.Config.Backend,
+		}).Error("Unable to upload kubeseal key in the backend configured")
+		os.Exit(1)
+	}
+	if !state.Config.DryRun {
+		log.WithFields(log.Fields{
+			"filename": keyName,
+			"backend":  state.Config.Backend,
+		}).Info("New key file has been uploaded")
+	}
+	return content
+}
+
+// replicate - Copy an already backed-up object to the backends missing it, existing copies are never rewritten. Like
+// an upload, it fails when the object is not stored on as many backends as BACKEND_POLICY requires.
+func replicate(ctx context.Context, state *config.State, b backend.Backend, keyName string, metadata map[string]string) {
+	replicator, ok := b.(backend.Replicator)
+	if !ok {
+		return
+	}
+	written, err := replicator.Replicate(ctx, keyName, metadata)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":    err.Error(),
+			"filename": keyName,
+			"backend":  state.Config.Backend,
+		}).Error("Unable to copy kubeseal key to the backends configured")
+		os.Exit(1)
+	}
+	if written > 0 {
+		log.WithFields(log.Fields{
+			"filename": keyName,
+			"copies":   written,
+		}).Info("Missing key copies have been written")
+	}
+}
+
+// UpdateCatalog - Utils to record backed-up keys and bundles in the CATALOG_NAME index of the backend. Keys whose
+// upload has been skipped and which are not in the catalog yet are downloaded to compute their checksum. The catalog
+// is only uploaded when an entry has been added or changed, or when the catalogs of multiple backends differed.
+func UpdateCatalog(ctx context.Context, state *config.State, b backend.Backend, entries []catalog.Entry, bundles []catalog.Bundle) {
+	name := state.Config.CatalogName
+	c, err := catalog.Load(ctx, b, name)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err.Error(),
+			"catalog": name,
+		}).Error("Unable to load backup catalog")
+		os.Exit(1)
+	}
+	// Copies of the catalog which missed updates are rewritten with the merged one.
+	changed := c.Diverged()
+	for _, entry := range entries {
+		if _, ok := c.Lookup(entry.Key); !ok && entry.Checksum == "" {
+			content, err := Download(ctx, b, entry.Key)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error":    err.Error(),
+					"filename": entry.Key,
+				}).Warning("Unable to download key to compute its checksum")
+			} else {
+				entry.Checksum = catalog.Checksum(content)
+				entry.Size = int64(len(content))
+			}
+			if info, err := b.Stat(ctx, entry.Key); err == nil {
+				entry.BackedUpAt = info.LastModified.UTC()
+			}
+		}
+		if c.Upsert(entry) {
+			changed = true
+		}
+	}
+	for _, bundle := range bundles {
+		if _, ok := c.LookupBundle(bundle.Key); !ok && bundle.BackedUpAt.IsZero() {
+			if info, err := b.Stat(ctx, bundle.Key); err == nil {
+				bundle.BackedUpAt = info.LastModified.UTC()
+			}
+		}
+		if c.UpsertBundle(bundle) {
+			changed = true
+		}
+	}
+	if !changed {
+		log.WithFields(log.Fields{
+			"catalog": name,
+		}).Info("Backup catalog is up to date")
+		return
+	}
+	err = c.Save(ctx, b, name)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err.Error(),
+			"catalog": name,
+		}).Error("Unable to upload backup catalog")
+		os.Exit(1)
+	}
+	if !state.Config.DryRun {
+		log.WithFields(log.Fields{
+			"catalog": name,
+			"keys":    len(c.Keys),
+		}).Info("Backup catalog has been updated")
+	}
+}
+
+// Download - Utils to read a whole object from the backend.
+func Download(ctx context.Context, b backend.Backend, key string) ([]byte, error) {
+	reader, err := b.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
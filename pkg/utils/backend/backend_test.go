@@ -0,0 +1,47 @@
+package backendutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestKeyName(t *testing.T) {
+	state := &config.State{Config: &config.Config{
+		ClusterName:                 "prod",
+		KubesealControllerNamespace: "kube-system",
+		KubesealControllerName:      "sealed-secrets",
+	}}
+	secret := v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "sealed-secrets-keyabcde"}}
+	created := time.Date(2021, 3, 4, 5, 6, 7, 0, time.FixedZone("CET", 3600))
+
+	tests := []struct {
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{
+			template: "{{.Namespace}}/{{.Controller}}/{{.Timestamp}}-{{.SecretName}}.yaml",
+			want:     "kube-system/sealed-secrets/20210304T040607Z-sealed-secrets-keyabcde.yaml",
+		},
+		{template: "{{.Cluster}}/{{.Fingerprint}}.yaml", want: "prod/0123abcd.yaml"},
+		{template: `{{.Time.Format "2006/01/02"}}/{{.SecretName}}`, want: "2021/03/04/sealed-secrets-keyabcde"},
+		{template: "/{{.SecretName}}", want: "sealed-secrets-keyabcde"},
+		{template: "{{.Missing}}", wantErr: true},
+		{template: "{{.SecretName", wantErr: true},
+		{template: "{{if false}}x{{end}}", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := KeyName(state, tt.template, secret, created, "0123abcd")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("KeyName(%q) error = %v, wantErr %v", tt.template, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("KeyName(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package catalogutils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+	"github.com/rayanebel/kubeseal-backuper/pkg/catalog"
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+	backendutils "github.com/rayanebel/kubeseal-backuper/pkg/utils/backend"
+	"github.com/rayanebel/kubeseal-backuper/pkg/utils/kubeseal"
+	restoreutils "github.com/rayanebel/kubeseal-backuper/pkg/utils/restore"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	kubesealSecretLabel = "sealedsecrets.bitnami.com/sealed-secrets-key"
+)
+
+// LoadCatalog - Utils to load the CATALOG_NAME catalog of the backend. It returns nil when the catalog is disabled.
+func LoadCatalog(ctx context.Context, state *config.State, b backend.Backend) *catalog.Catalog {
+	if state.Config.CatalogName == "" {
+		return nil
+	}
+	c, err := catalog.Load(ctx, b, state.Config.CatalogName)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err.Error(),
+			"catalog": state.Config.CatalogName,
+		}).Error("Unable to load backup catalog")
+		os.Exit(1)
+	}
+	return c
+}
+
+// controllerEntries - Catalog entries of the configured controller of the configured cluster.
+func controllerEntries(state *config.State, c *catalog.Catalog) []catalog.Entry {
+	var entries []catalog.Entry
+	if c == nil {
+		return entries
+	}
+	for _, entry := range c.Keys {
+		if entry.Owned(state.Config.KubesealControllerNamespace, state.Config.KubesealControllerName, state.Config.ClusterName) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// controllerBundles - Catalog bundles of the configured controller of the configured cluster.
+func controllerBundles(state *config.State, c *catalog.Catalog) []catalog.Bundle {
+	var bundles []catalog.Bundle
+	for _, bundle := range c.Bundles {
+		if bundle.Owned(state.Config.KubesealControllerNamespace, state.Config.KubesealControllerName, state.Config.ClusterName) {
+			bundles = append(bundles, bundle)
+		}
+	}
+	return bundles
+}
+
+// clusterKeys - Kubeseal keys currently stored in the cluster.
+func clusterKeys(state *config.State) ([]v1.Secret, error) {
+	opts := metav1.ListOptions{
+		LabelSelector: kubesealSecretLabel,
+	}
+	secrets, err := state.K8s.ListSecrets(state.Config.KubesealControllerNamespace, opts)
+	if err != nil {
+		return nil, err
+	}
+	return kubeseal.FindSecretsByPrefix(secrets, state.Config.KubesealKeyPrefix)
+}
+
+// ListKeys - Utils to print the backed-up keys of the controller as a table or as JSON. Keys are read from the
+// catalog, or downloaded when there is none.
+func ListKeys(ctx context.Context, state *config.State, b backend.Backend, output string, w io.Writer) {
+	entries := controllerEntries(state, LoadCatalog(ctx, state, b))
+	if len(entries) == 0 {
+		for _, secret := range restoreutils.FetchKeys(ctx, state, b) {
+			entry := catalog.Entry{
+				SecretName: secret.Name,
+				Namespace:  state.Config.KubesealControllerNamespace,
+				Controller: state.Config.KubesealControllerName,
+			}
+			entry.Fingerprint, _ = kubeseal.CertificateFingerprint(secret)
+			if cert, err := kubeseal.ParseCertificate(secret); err == nil {
+				entry.NotBefore = cert.NotBefore.UTC()
+				entry.NotAfter = cert.NotAfter.UTC()
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	var err error
+	switch output {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(entries)
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "SECRET\tFINGERPRINT\tNOT BEFORE\tNOT AFTER\tLOCATION")
+		for _, entry := range entries {
+			location := entry.Key
+			if location == "" {
+				location = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.SecretName, entry.Fingerprint,
+				entry.NotBefore.Format(time.RFC3339), entry.NotAfter.Format(time.RFC3339), location)
+		}
+		err = tw.Flush()
+	default:
+		err = fmt.Errorf("Unsupported output %s (available: table, json)", output)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Error("Unable to list backed-up keys")
+		os.Exit(1)
+	}
+}
+
+// VerifyBackups - Utils to check that every catalog entry of the controller can be downloaded, matches its checksum
+// and decrypts to the expected key, and that every key of the cluster has been backed up. It returns the number of
+// problems found.
+func VerifyBackups(ctx context.Context, state *config.State, b backend.Backend) int {
+	problems := 0
+	backedUp := map[string]bool{}
+
+	entries := controllerEntries(state, LoadCatalog(ctx, state, b))
+	for _, entry := range entries {
+		fields := log.Fields{
+			"filename": entry.Key,
+			"secret":   entry.SecretName,
+		}
+		content, err := backendutils.Download(ctx, b, entry.Key)
+		if err != nil {
+			fields["error"] = err.Error()
+			log.WithFields(fields).Error("Unable to download backup")
+			problems++
+			continue
+		}
+		if entry.Checksum != "" && catalog.Checksum(content) != entry.Checksum {
+			log.WithFields(fields).Error("Backup checksum does not match the catalog")
+			problems++
+			continue
+		}
+		secrets, err := restoreutils.DecodeSecrets(state, entry.Key, bytes.NewReader(content))
+		if err != nil {
+			fields["error"] = err.Error()
+			log.WithFields(fields).Error("Unable to decrypt backup")
+			problems++
+			continue
+		}
+		found := false
+		for _, secret := range secrets {
+			fingerprint, err := kubeseal.CertificateFingerprint(secret)
+			if err == nil && secret.Name == entry.SecretName && fingerprint == entry.Fingerprint {
+				found = true
+			}
+		}
+		if !found {
+			log.WithFields(fields).Error("Backup does not hold the key recorded in the catalog")
+			problems++
+			continue
+		}
+		backedUp[entry.Fingerprint] = true
+		log.WithFields(fields).Info("Backup verified")
+	}
+	if len(entries) == 0 {
+		for _, secret := range restoreutils.FetchKeys(ctx, state, b) {
+			if fingerprint, err := kubeseal.CertificateFingerprint(secret); err == nil {
+				backedUp[fingerprint] = true
+			}
+		}
+	}
+
+	secrets, err := clusterKeys(state)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":     err.Error(),
+			"namespace": state.Config.KubesealControllerNamespace,
+		}).Warning("Unable to list the keys of the cluster")
+	}
+	for _, secret := range secrets {
+		fingerprint, err := kubeseal.CertificateFingerprint(secret)
+		if err != nil || !backedUp[fingerprint] {
+			log.WithFields(log.Fields{
+				"secret": secret.Name,
+			}).Error("Key of the cluster has no valid backup")
+			problems++
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"backups":  len(backedUp),
+		"problems": problems,
+	}).Info("Backup verification done")
+	return problems
+}
+
+// decommissionedKeys - Fingerprints of the keys of the cluster marked as compromised.
+func decommissionedKeys(state *config.State) (map[string]bool, error) {
+	opts := metav1.ListOptions{
+		LabelSelector: kubesealSecretLabel + "=compromised",
+	}
+	list, err := state.K8s.SearchSecrets(state.Config.KubesealControllerNamespace, opts)
+	if err != nil {
+		return nil, err
+	}
+	fingerprints := map[string]bool{}
+	for _, secret := range list.Items {
+		if fingerprint, err := kubeseal.CertificateFingerprint(secret); err == nil {
+			fingerprints[fingerprint] = true
+		}
+	}
+	return fingerprints, nil
+}
+
+// PruneBackups - Utils to drop the catalog entries whose object is gone, then to delete the backups of the keys
+// listed in fingerprints and, when olderThan is set, of the keys decommissioned in the cluster and created more than
+// olderThan ago. The bundles holding a deleted key are deleted as well. A backup is never deleted because its key is
+// missing from the cluster, as this is when it is needed. It returns the number of deleted backups.
+func PruneBackups(ctx context.Context, state *config.State, b backend.Backend, olderThan time.Duration, fingerprints []string) int {
+	c := LoadCatalog(ctx, state, b)
+	if c == nil {
+		log.WithFields(log.Fields{}).Error("Pruning requires the backup catalog, please set CATALOG_NAME")
+		os.Exit(1)
+	}
+
+	listed := map[string]bool{}
+	for _, fingerprint := range fingerprints {
+		listed[fingerprint] = true
+	}
+	decommissioned := map[string]bool{}
+	if olderThan > 0 {
+		// Without a cluster name, the keys of every cluster sharing the catalog would be considered.
+		if state.Config.ClusterName == "" {
+			log.WithFields(log.Fields{}).Error("Deleting backups of decommissioned keys requires CLUSTER_NAME, please set it")
+			os.Exit(1)
+		}
+		var err error
+		decommissioned, err = decommissionedKeys(state)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":     err.Error(),
+				"namespace": state.Config.KubesealControllerNamespace,
+			}).Error("Unable to list the decommissioned keys of the cluster")
+			os.Exit(1)
+		}
+	}
+
+	pruned := 0
+	changed := c.Diverged()
+	deleted := map[string]bool{}
+	for _, entry := range controllerEntries(state, c) {
+		_, err := b.Stat(ctx, entry.Key)
+		if err == backend.ErrNotFound {
+			log.WithFields(log.Fields{
+				"filename": entry.Key,
+			}).Warning("Backup is missing, dropping it from the catalog")
+			changed = c.Remove(entry.Key) || changed
+			continue
+		}
+		created := entry.CreatedAt
+		if created.IsZero() {
+			created = entry.BackedUpAt
+		}
+		expired := decommissioned[entry.Fingerprint] && time.Since(created) >= olderThan
+		if !listed[entry.Fingerprint] && !expired {
+			continue
+		}
+		deleteBackup(ctx, b, entry.Key)
+		log.WithFields(log.Fields{
+			"filename": entry.Key,
+			"secret":   entry.SecretName,
+		}).Warning("Backup has been pruned")
+		changed = c.Remove(entry.Key) || changed
+		deleted[entry.Fingerprint] = true
+		pruned++
+	}
+
+	for _, bundle := range controllerBundles(state, c) {
+		_, err := b.Stat(ctx, bundle.Key)
+		if err == backend.ErrNotFound {
+			log.WithFields(log.Fields{
+				"filename": bundle.Key,
+			}).Warning("Bundle is missing, dropping it from the catalog")
+			changed = c.RemoveBundle(bundle.Key) || changed
+			continue
+		}
+		holds := false
+		for fingerprint := range deleted {
+			holds = holds || bundle.Holds(fingerprint)
+		}
+		if !holds {
+			continue
+		}
+		deleteBackup(ctx, b, bundle.Key)
+		log.WithFields(log.Fields{
+			"filename": bundle.Key,
+		}).Warning("Bundle holding a pruned key has been deleted")
+		changed = c.RemoveBundle(bundle.Key) || changed
+	}
+
+	if !changed {
+		return pruned
+	}
+	err := c.Save(ctx, b, state.Config.CatalogName)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err.Error(),
+			"catalog": state.Config.CatalogName,
+		}).Error("Unable to upload backup catalog")
+		os.Exit(1)
+	}
+	return pruned
+}
+
+// deleteBackup - Delete an object, which may already be gone.
+func deleteBackup(ctx context.Context, b backend.Backend, key string) {
+	err := b.Delete(ctx, key)
+	if err != nil && err != backend.ErrNotFound {
+		log.WithFields(log.Fields{
+			"error":    err.Error(),
+			"filename": key,
+		}).Error("Unable to delete backup")
+		os.Exit(1)
+	}
+}
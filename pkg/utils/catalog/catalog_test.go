@@ -0,0 +1,94 @@
+package catalogutils
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend/filesystem"
+	"github.com/rayanebel/kubeseal-backuper/pkg/catalog"
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+)
+
+func TestPruneBackups(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "prune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := filesystem.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &config.State{Config: &config.Config{
+		CatalogName:                 "index.json",
+		ClusterName:                 "prod",
+		KubesealControllerNamespace: "kube-system",
+		KubesealControllerName:      "sealed-secrets",
+	}}
+
+	entry := func(key string, fingerprint string, cluster string) catalog.Entry {
+		return catalog.Entry{Key: key, Fingerprint: fingerprint, Namespace: "kube-system", Controller: "sealed-secrets", Cluster: cluster}
+	}
+	bundle := func(key string, fingerprints ...string) catalog.Bundle {
+		return catalog.Bundle{Key: key, Fingerprints: fingerprints, Namespace: "kube-system", Controller: "sealed-secrets", Cluster: "prod"}
+	}
+	c := &catalog.Catalog{
+		Keys: []catalog.Entry{
+			entry("a.yaml", "aaa", "prod"),
+			entry("b.yaml", "bbb", "prod"),
+			entry("missing.yaml", "ccc", "prod"),
+			entry("staging-a.yaml", "aaa", "staging"),
+		},
+		Bundles: []catalog.Bundle{
+			bundle("bundle-1.yaml", "aaa"),
+			bundle("bundle-2.yaml", "aaa", "bbb"),
+			bundle("bundle-3.yaml", "bbb"),
+		},
+	}
+	for _, key := range []string{"a.yaml", "b.yaml", "staging-a.yaml", "bundle-1.yaml", "bundle-2.yaml", "bundle-3.yaml"} {
+		if err := b.Put(ctx, key, strings.NewReader(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Save(ctx, b, "index.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys missing from the cluster are kept: without -older-than, only the listed fingerprint is deleted.
+	if pruned := PruneBackups(ctx, state, b, 0, []string{"aaa"}); pruned != 1 {
+		t.Errorf("PruneBackups = %d, want 1", pruned)
+	}
+
+	for key, want := range map[string]bool{
+		"a.yaml":         false,
+		"b.yaml":         true,
+		"staging-a.yaml": true,
+		"bundle-1.yaml":  false,
+		"bundle-2.yaml":  false,
+		"bundle-3.yaml":  true,
+	} {
+		_, err := b.Stat(ctx, key)
+		if exists := err != backend.ErrNotFound; exists != want {
+			t.Errorf("%s exists = %v, want %v", key, exists, want)
+		}
+	}
+	c, err = catalog.Load(ctx, b, "index.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, entry := range c.Keys {
+		keys = append(keys, entry.Key)
+	}
+	for _, bundle := range c.Bundles {
+		keys = append(keys, bundle.Key)
+	}
+	if got := strings.Join(keys, ","); got != "b.yaml,staging-a.yaml,bundle-3.yaml" {
+		t.Errorf("catalog = %s, want b.yaml,staging-a.yaml,bundle-3.yaml", got)
+	}
+}
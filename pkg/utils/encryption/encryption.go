@@ -0,0 +1,78 @@
+package encryptionutils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+	"github.com/rayanebel/kubeseal-backuper/pkg/encryption"
+	log "github.com/sirupsen/logrus"
+
+	// Encryption providers available through the ENCRYPTION setting.
+	_ "github.com/rayanebel/kubeseal-backuper/pkg/encryption/age"
+	_ "github.com/rayanebel/kubeseal-backuper/pkg/encryption/kms"
+	_ "github.com/rayanebel/kubeseal-backuper/pkg/encryption/pgp"
+)
+
+// InitEncryption - Utils to init the client-side encryption provider. It returns nil when encryption is disabled.
+func InitEncryption(state *config.State) encryption.Encrypter {
+	if state.Config.Encryption == "" {
+		log.Warning("Client-side encryption is disabled, keys will be stored in clear text")
+		return nil
+	}
+	enc, err := encryption.New(state.Config.Encryption, state)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":      err.Error(),
+			"encryption": state.Config.Encryption,
+		}).Error("Unable to init encryption provider")
+		os.Exit(1)
+	}
+	return enc
+}
+
+// Extension - Utils to get the suffix of encrypted object names, empty when encryption is disabled.
+func Extension(state *config.State) string {
+	if state.Config.Encryption == "" {
+		return ""
+	}
+	return encryption.Extension(state.Config.Encryption)
+}
+
+// EncryptPayload - Utils to encrypt a payload before it is handed to a backend. The payload is returned as is when
+// enc is nil.
+func EncryptPayload(enc encryption.Encrypter, payload io.Reader) (io.Reader, error) {
+	if enc == nil {
+		return payload, nil
+	}
+	var buf bytes.Buffer
+	writer, err := enc.Encrypt(&buf)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(writer, payload)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// DecryptPayload - Utils to decrypt an object downloaded from a backend. The provider is selected from the object
+// name and plaintext objects are returned as is.
+func DecryptPayload(state *config.State, key string, payload io.Reader) (io.Reader, error) {
+	name := encryption.ForObject(key)
+	if name == "" {
+		return payload, nil
+	}
+	enc, err := encryption.New(name, state)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to init %s encryption provider: %s", name, err.Error())
+	}
+	return enc.Decrypt(payload)
+}
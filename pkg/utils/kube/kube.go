@@ -5,11 +5,14 @@ import (
 	"io/ioutil"
 	"os"
 	"sort"
+	"time"
 
 	"github.com/rayanebel/kubeseal-backuper/pkg/config"
 	"github.com/rayanebel/kubeseal-backuper/pkg/kube"
 	"github.com/rayanebel/kubeseal-backuper/pkg/utils/kubeseal"
 	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -21,6 +24,13 @@ import (
 
 const (
 	kubesealSecretLabel = "sealedsecrets.bitnami.com/sealed-secrets-key"
+	// KubesealPodLabels - Selector of the kubeseal controller pods when the controller deployment cannot be read.
+	KubesealPodLabels = "app.kubernetes.io/instance=kubeseal"
+	// restartedAtAnnotation - Pod template annotation set by a rollout restart, the same as kubectl.
+	restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+	rolloutPollInterval   = 2 * time.Second
+	// kubesealCertPath - Path of the public certificate served by the kubeseal controller.
+	kubesealCertPath = "/v1/cert.pem"
 )
 
 // KubernetesJson2Yaml - Utils to convert k8s json into yaml
@@ -104,6 +114,7 @@ func SetKubernetesclient(state *config.State) {
 		log.WithFields(log.Fields{}).Error("Unable to init kubernetes client: client mode set is invalid.")
 		os.Exit(1)
 	}
+	state.K8s.DryRun = state.Config.DryRun
 }
 
 // RestartKubesealPods - Utils to restart kubeseal pods by deleting them and let k8s recreate them.
@@ -129,23 +140,284 @@ func RestartKubesealPods(labels string, state *config.State) {
 	}
 }
 
-// CleanSecret - Utils to cleanup secret by updating custom labels and restarting kubeseal pods.
-func CleanSecret(state *config.State) {
+// KubesealPodSelector - Utils to find the label selector of the kubeseal controller pods. KUBESEAL_POD_SELECTOR is
+// used when set, otherwise the selector of the KUBESEAL_CONTROLLER_NAME deployment.
+func KubesealPodSelector(state *config.State) string {
+	if state.Config.KubesealPodSelector != "" {
+		return state.Config.KubesealPodSelector
+	}
+	deployment, err := state.K8s.GetDeployment(state.Config.KubesealControllerNamespace, state.Config.KubesealControllerName)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":      err.Error(),
+			"deployment": state.Config.KubesealControllerName,
+			"selector":   KubesealPodLabels,
+		}).Warning("Unable to read the kubeseal controller deployment, using the default pod selector")
+		return KubesealPodLabels
+	}
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err == nil && (deployment.Spec.Selector == nil || selector.Empty()) {
+		// An empty selector would match every pod of the namespace.
+		err = fmt.Errorf("Deployment %s has no pod selector", state.Config.KubesealControllerName)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":      err.Error(),
+			"deployment": state.Config.KubesealControllerName,
+		}).Error("Invalid pod selector in the kubeseal controller deployment")
+		os.Exit(1)
+	}
+	return selector.String()
+}
+
+// RestartKubeseal - Utils to restart the kubeseal controller with KUBESEAL_RESTART_STRATEGY: delete deletes the pods
+// at once, rollout restarts the controller deployment and waits for the rollout to be ready.
+func RestartKubeseal(state *config.State) {
+	switch state.Config.KubesealRestartStrategy {
+	case "delete":
+		labels := KubesealPodSelector(state)
+		log.WithFields(log.Fields{
+			"labels": labels,
+		}).Warning("Restarting kubeseal controller with labels")
+		RestartKubesealPods(labels, state)
+	case "rollout":
+		log.WithFields(log.Fields{
+			"deployment": state.Config.KubesealControllerName,
+		}).Warning("Restarting kubeseal controller deployment")
+		RolloutRestartKubeseal(state)
+	default:
+		log.WithFields(log.Fields{
+			"strategy": state.Config.KubesealRestartStrategy,
+		}).Error("Unsupported restart strategy (available: delete, rollout)")
+		os.Exit(1)
+	}
+	log.WithFields(log.Fields{}).Info("Kubeseal controller has been restarted.")
+
+	if state.Config.KubesealVerifyRestart && !state.K8s.DryRun {
+		WaitForKubesealPods(state)
+		VerifyKubesealCertificate(state)
+	}
+}
+
+// WaitForKubesealPods - Utils to wait up to KUBESEAL_ROLLOUT_TIMEOUT for every kubeseal controller pod to be Ready.
+// Pods being deleted are ignored.
+func WaitForKubesealPods(state *config.State) {
+	opts := metav1.ListOptions{
+		LabelSelector: KubesealPodSelector(state),
+	}
+	deadline := time.Now().Add(state.Config.KubesealRolloutTimeout)
+	for {
+		ready, total := 0, 0
+		pods, err := state.K8s.ListPods(state.Config.KubesealControllerNamespace, opts)
+		if err == nil {
+			for _, pod := range pods.Items {
+				if pod.DeletionTimestamp != nil {
+					continue
+				}
+				total++
+				if podReady(pod) {
+					ready++
+				}
+			}
+		}
+		if total > 0 && ready == total {
+			log.WithFields(log.Fields{
+				"pods": total,
+			}).Info("Kubeseal controller pods are ready")
+			return
+		}
+		if time.Now().After(deadline) {
+			log.WithFields(log.Fields{
+				"labels":  opts.LabelSelector,
+				"ready":   ready,
+				"pods":    total,
+				"timeout": state.Config.KubesealRolloutTimeout,
+			}).Error("Timed out waiting for the kubeseal controller pods to be ready")
+			os.Exit(1)
+		}
+		log.WithFields(log.Fields{
+			"ready": ready,
+			"pods":  total,
+		}).Info("Waiting for the kubeseal controller pods to be ready")
+		time.Sleep(rolloutPollInterval)
+	}
+}
+
+// podReady - Check the Ready condition of a pod.
+func podReady(pod v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// VerifyKubesealCertificate - Utils to check that the certificate served by the kubeseal controller, fetched through
+// the API server service proxy, is the one of the newest active key. The controller is given up to
+// KUBESEAL_ROLLOUT_TIMEOUT to serve it.
+func VerifyKubesealCertificate(state *config.State) {
+	latest, err := latestActiveKey(state)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":     err.Error(),
+			"namespace": state.Config.KubesealControllerNamespace,
+		}).Error("Unable to find the newest kubeseal key")
+		os.Exit(1)
+	}
+	expected, err := kubeseal.CertificateFingerprint(latest)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err.Error(),
+			"secret": latest.Name,
+		}).Error("Unable to read the certificate of the newest kubeseal key")
+		os.Exit(1)
+	}
+
+	deadline := time.Now().Add(state.Config.KubesealRolloutTimeout)
+	for {
+		fields := log.Fields{
+			"service":  state.Config.KubesealControllerName,
+			"expected": expected,
+			"secret":   latest.Name,
+		}
+		cert, err := state.K8s.ProxyGetService(state.Config.KubesealControllerNamespace, "http", state.Config.KubesealControllerName, "", kubesealCertPath)
+		if err == nil {
+			var served string
+			served, err = kubeseal.PEMFingerprint(cert)
+			if err == nil && served == expected {
+				log.WithFields(fields).Info("Kubeseal controller serves the newest certificate")
+				return
+			}
+			if err == nil {
+				fields["served"] = served
+				err = fmt.Errorf("Served certificate does not match the newest key")
+			}
+		}
+		fields["error"] = err.Error()
+		if time.Now().After(deadline) {
+			log.WithFields(fields).Error("Kubeseal controller does not serve the newest certificate")
+			os.Exit(1)
+		}
+		log.WithFields(fields).Info("Waiting for the kubeseal controller to serve the newest certificate")
+		time.Sleep(rolloutPollInterval)
+	}
+}
+
+// latestActiveKey - Newest active kubeseal key of the controller.
+func latestActiveKey(state *config.State) (v1.Secret, error) {
+	opts := metav1.ListOptions{
+		LabelSelector: kubesealSecretLabel + "=active",
+	}
+	list, err := state.K8s.ListSecrets(state.Config.KubesealControllerNamespace, opts)
+	if err != nil {
+		return v1.Secret{}, err
+	}
+	secrets, err := kubeseal.FindSecretsByPrefix(list, state.Config.KubesealKeyPrefix)
+	if err != nil {
+		return v1.Secret{}, err
+	}
+	return secrets[len(secrets)-1], nil
+}
+
+// RolloutRestartKubeseal - Utils to restart the kubeseal controller deployment like kubectl rollout restart, then wait
+// up to KUBESEAL_ROLLOUT_TIMEOUT for the rollout to be ready.
+func RolloutRestartKubeseal(state *config.State) {
+	namespace := state.Config.KubesealControllerNamespace
+	name := state.Config.KubesealControllerName
+	patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{%q:%q}}}}}`,
+		restartedAtAnnotation, time.Now().UTC().Format(time.RFC3339))
+	_, err := state.K8s.PatchDeployment(namespace, name, []byte(patch))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":      err.Error(),
+			"deployment": name,
+			"namespace":  namespace,
+		}).Error("Unable to restart kubeseal controller deployment")
+		os.Exit(1)
+	}
+	if state.K8s.DryRun {
+		return
+	}
+
+	deadline := time.Now().Add(state.Config.KubesealRolloutTimeout)
+	for {
+		deployment, err := state.K8s.GetDeployment(namespace, name)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":      err.Error(),
+				"deployment": name,
+			}).Error("Unable to read kubeseal controller deployment")
+			os.Exit(1)
+		}
+		if rolloutComplete(deployment) {
+			return
+		}
+		if time.Now().After(deadline) {
+			log.WithFields(log.Fields{
+				"deployment": name,
+				"timeout":    state.Config.KubesealRolloutTimeout,
+			}).Error("Timed out waiting for the kubeseal controller rollout")
+			os.Exit(1)
+		}
+		log.WithFields(log.Fields{
+			"deployment": name,
+			"updated":    deployment.Status.UpdatedReplicas,
+			"available":  deployment.Status.AvailableReplicas,
+		}).Info("Waiting for the kubeseal controller rollout")
+		time.Sleep(rolloutPollInterval)
+	}
+}
+
+// rolloutComplete - Check whether every replica of a deployment runs its latest template and is available.
+func rolloutComplete(deployment *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	status := deployment.Status
+	return status.ObservedGeneration >= deployment.Generation &&
+		status.UpdatedReplicas == replicas &&
+		status.Replicas == status.UpdatedReplicas &&
+		status.AvailableReplicas == status.UpdatedReplicas
+}
+
+// CleanSecret - Utils to cleanup secret by updating custom labels and restarting kubeseal pods. The
+// DECOMMISSION_KEEP_LATEST newest keys and the keys replaced less than DECOMMISSION_GRACE_PERIOD ago are kept active.
+// It returns the number of decommissioned keys.
+func CleanSecret(state *config.State) int {
 
 	labelSelector := kubesealSecretLabel
 	opts := metav1.ListOptions{
 		LabelSelector: labelSelector,
 	}
-	list, _ := state.K8s.ListSecrets(state.Config.KubesealControllerNamespace, opts)
-	sort.Sort(kubeseal.ByCreationTimestamp(list.Items))
+	list, err := state.K8s.ListSecrets(state.Config.KubesealControllerNamespace, opts)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":     err.Error(),
+			"namespace": state.Config.KubesealControllerNamespace,
+		}).Error("Unable to list kubeseal keys")
+		os.Exit(1)
+	}
+	if len(list.Items) == 0 {
+		log.WithFields(log.Fields{
+			"namespace": state.Config.KubesealControllerNamespace,
+		}).Warning("No kubeseal key found, nothing to decommission")
+		return 0
+	}
+	sort.Stable(kubeseal.ByCreationTimestamp(list.Items))
 	latestKey := &list.Items[len(list.Items)-1]
 
 	log.WithFields(log.Fields{
-		"latest": latestKey.Name,
+		"latest":      latestKey.Name,
+		"keepLatest":  state.Config.DecommissionKeepLatest,
+		"gracePeriod": state.Config.DecommissionGracePeriod,
 	}).Info("Latest sealed secret")
 
-	for _, key := range list.Items {
-		if key.Name == latestKey.Name {
+	decommissioned := 0
+	expired := kubeseal.ExpiredSecrets(list.Items, state.Config.DecommissionKeepLatest, state.Config.DecommissionGracePeriod, time.Now())
+	for _, key := range expired {
+		if key.Labels[kubesealSecretLabel] == "compromised" {
 			continue
 		}
 		log.WithFields(log.Fields{
@@ -153,12 +425,20 @@ func CleanSecret(state *config.State) {
 		}).Info("Disable secret key")
 
 		key.Labels[kubesealSecretLabel] = "compromised"
-		state.K8s.UpdateSecret(&key)
+		err = state.K8s.UpdateSecret(&key)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err.Error(),
+				"key":   key.Name,
+			}).Error("Unable to disable secret key")
+			os.Exit(1)
+		}
+		decommissioned++
 	}
-	log.WithFields(log.Fields{
-		"labels": "app.kubernetes.io/instance=kubeseal",
-	}).Warning("Restarting kubeseal controller with labels")
-
-	RestartKubesealPods("app.kubernetes.io/instance=kubeseal", state)
-	log.WithFields(log.Fields{}).Info("Kubeseal controller has been restarted.")
+	if decommissioned == 0 {
+		log.WithFields(log.Fields{}).Info("No key to decommission, kubeseal controller is left untouched")
+		return 0
+	}
+	RestartKubeseal(state)
+	return decommissioned
 }
@@ -1,10 +1,31 @@
 package kubeseal
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
+	"math/big"
+	"sort"
 	"strings"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	kubesealSecretLabel = "sealedsecrets.bitnami.com/sealed-secrets-key"
+	// Key format of the sealed-secrets controller.
+	keySize        = 4096
+	keyValidity    = 10 * 365 * 24 * time.Hour
+	certCommonName = "sealed-secret"
+	nameSuffixSize = 5
+	nameAlphabet   = "bcdfghjklmnpqrstvwxz2456789"
 )
 
 type ByCreationTimestamp []v1.Secret
@@ -21,17 +42,123 @@ func (s ByCreationTimestamp) Less(i, j int) bool {
 	return s[i].GetCreationTimestamp().Unix() < s[j].GetCreationTimestamp().Unix()
 }
 
-func FindSecretByPrefix(secrets *v1.SecretList, prefix string) (v1.Secret, error) {
-	var kubesealSecret v1.Secret
+// FindSecretsByPrefix - Return every secret whose name starts with prefix, from the oldest to the newest.
+func FindSecretsByPrefix(secrets *v1.SecretList, prefix string) ([]v1.Secret, error) {
+	var kubesealSecrets []v1.Secret
 
 	for _, item := range secrets.Items {
 		if strings.HasPrefix(item.Name, prefix) {
-			kubesealSecret = item
-			break
-		} else {
-			err := fmt.Errorf("No secret with prefix %s was found.", prefix)
-			return kubesealSecret, err
+			kubesealSecrets = append(kubesealSecrets, item)
+		}
+	}
+	if len(kubesealSecrets) == 0 {
+		err := fmt.Errorf("No secret with prefix %s was found.", prefix)
+		return nil, err
+	}
+	sort.Stable(ByCreationTimestamp(kubesealSecrets))
+	return kubesealSecrets, nil
+}
+
+// ParseCertificate - Parse the certificate stored in a kubeseal secret.
+func ParseCertificate(secret v1.Secret) (*x509.Certificate, error) {
+	block, _ := pem.Decode(secret.Data[v1.TLSCertKey])
+	if block == nil {
+		return nil, fmt.Errorf("No PEM certificate found in secret %s", secret.Name)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// CertificateFingerprint - Compute the SHA-256 fingerprint of the certificate stored in a kubeseal secret.
+func CertificateFingerprint(secret v1.Secret) (string, error) {
+	fingerprint, err := PEMFingerprint(secret.Data[v1.TLSCertKey])
+	if err != nil {
+		return "", fmt.Errorf("No PEM certificate found in secret %s", secret.Name)
+	}
+	return fingerprint, nil
+}
+
+// PEMFingerprint - Compute the SHA-256 fingerprint of a PEM encoded certificate.
+func PEMFingerprint(data []byte) (string, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return "", fmt.Errorf("No PEM certificate found")
+	}
+	sum := sha256.Sum256(block.Bytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ExpiredSecrets - Return the secrets to decommission, secrets being sorted from the oldest to the newest. The
+// keepLatest newest secrets are kept, as well as the secrets replaced by a newer one less than grace ago.
+func ExpiredSecrets(secrets []v1.Secret, keepLatest int, grace time.Duration, now time.Time) []v1.Secret {
+	var expired []v1.Secret
+	if keepLatest < 1 {
+		keepLatest = 1
+	}
+	for i := 0; i < len(secrets)-keepLatest; i++ {
+		replacedAt := secrets[i+1].CreationTimestamp.Time
+		if now.Sub(replacedAt) < grace {
+			continue
+		}
+		expired = append(expired, secrets[i])
+	}
+	return expired
+}
+
+// GenerateKeySecret - Generate a kubeseal key secret in the format of the sealed-secrets controller: an RSA 4096 key
+// and a self-signed certificate valid for 10 years, stored in an active kubernetes.io/tls secret named after prefix.
+// The creation date is set to now, as the secret is backed up before it is created in the cluster.
+func GenerateKeySecret(namespace string, prefix string) (*v1.Secret, error) {
+	key, err := rsa.GenerateKey(rand.Reader, keySize)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		KeyUsage:              x509.KeyUsageEncipherOnly,
+		NotBefore:             now.UTC(),
+		NotAfter:              now.Add(keyValidity).UTC(),
+		Subject:               pkix.Name{CommonName: certCommonName},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	suffix, err := randomSuffix()
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              prefix + suffix,
+			Namespace:         namespace,
+			Labels:            map[string]string{kubesealSecretLabel: "active"},
+			CreationTimestamp: metav1.NewTime(now),
+		},
+		Type: v1.SecretTypeTLS,
+		Data: map[string][]byte{
+			v1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+			v1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		},
+	}, nil
+}
+
+// randomSuffix - Random name suffix, like the ones generated by the API server.
+func randomSuffix() (string, error) {
+	suffix := make([]byte, nameSuffixSize)
+	for i := range suffix {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(nameAlphabet))))
+		if err != nil {
+			return "", err
 		}
+		suffix[i] = nameAlphabet[n.Int64()]
 	}
-	return kubesealSecret, nil
+	return string(suffix), nil
 }
@@ -0,0 +1,50 @@
+package kubeseal
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExpiredSecrets(t *testing.T) {
+	t0 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	var secrets []v1.Secret
+	for i, name := range []string{"a", "b", "c", "d"} {
+		secrets = append(secrets, v1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(t0.Add(time.Duration(i) * 24 * time.Hour)),
+		}})
+	}
+	// d is created at t0+72h.
+	now := t0.Add(96 * time.Hour)
+
+	tests := []struct {
+		name       string
+		secrets    []v1.Secret
+		keepLatest int
+		grace      time.Duration
+		want       []string
+	}{
+		{name: "no secret", keepLatest: 1},
+		{name: "single secret", secrets: secrets[:1], keepLatest: 1},
+		{name: "keep the newest", secrets: secrets, keepLatest: 1, want: []string{"a", "b", "c"}},
+		{name: "keep at least one", secrets: secrets, keepLatest: 0, want: []string{"a", "b", "c"}},
+		{name: "keep two", secrets: secrets, keepLatest: 2, want: []string{"a", "b"}},
+		{name: "keep more than available", secrets: secrets, keepLatest: 5},
+		{name: "grace period", secrets: secrets, keepLatest: 1, grace: 36 * time.Hour, want: []string{"a", "b"}},
+		{name: "grace period covering all", secrets: secrets, keepLatest: 1, grace: 96 * time.Hour},
+		{name: "grace period elapsed", secrets: secrets, keepLatest: 1, grace: 24 * time.Hour, want: []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, secret := range ExpiredSecrets(tt.secrets, tt.keepLatest, tt.grace, now) {
+			got = append(got, secret.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ExpiredSecrets = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package restoreutils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rayanebel/kubeseal-backuper/pkg/backend"
+	"github.com/rayanebel/kubeseal-backuper/pkg/catalog"
+	"github.com/rayanebel/kubeseal-backuper/pkg/config"
+	encryptionutils "github.com/rayanebel/kubeseal-backuper/pkg/utils/encryption"
+	k8sutils "github.com/rayanebel/kubeseal-backuper/pkg/utils/kube"
+	"github.com/rayanebel/kubeseal-backuper/pkg/utils/kubeseal"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const (
+	kubesealSecretLabel = "sealedsecrets.bitnami.com/sealed-secrets-key"
+)
+
+// Selector - Keys selected by RESTORE_KEYS.
+type Selector struct {
+	// Fingerprints - Certificate fingerprints, or prefixes of them, to restore. Every key is selected when empty.
+	Fingerprints []string
+	// Until - Only select keys whose certificate was issued before this date.
+	Until time.Time
+}
+
+// ParseSelector - Parse a key selector: all, fingerprint:<sha256>[,<sha256>...] or date:<YYYY-MM-DD|RFC3339>.
+func ParseSelector(value string) (*Selector, error) {
+	switch {
+	case value == "" || value == "all":
+		return &Selector{}, nil
+	case strings.HasPrefix(value, "fingerprint:"):
+		var fingerprints []string
+		for _, fingerprint := range strings.Split(strings.TrimPrefix(value, "fingerprint:"), ",") {
+			fingerprint = strings.ToLower(strings.Replace(strings.TrimSpace(fingerprint), ":", "", -1))
+			if fingerprint != "" {
+				fingerprints = append(fingerprints, fingerprint)
+			}
+		}
+		if len(fingerprints) == 0 {
+			return nil, fmt.Errorf("No fingerprint given in selector %s", value)
+		}
+		return &Selector{Fingerprints: fingerprints}, nil
+	case strings.HasPrefix(value, "date:"):
+		date := strings.TrimPrefix(value, "date:")
+		until, err := time.Parse(time.RFC3339, date)
+		if err != nil {
+			day, dayErr := time.Parse("2006-01-02", date)
+			if dayErr != nil {
+				return nil, fmt.Errorf("Invalid date %s, expected YYYY-MM-DD or RFC3339", date)
+			}
+			until = day.Add(24*time.Hour - time.Nanosecond)
+		}
+		return &Selector{Until: until}, nil
+	default:
+		return nil, fmt.Errorf("Invalid key selector %s (available: all, fingerprint:<sha256>, date:<YYYY-MM-DD>)", value)
+	}
+}
+
+// Match - Check whether a key is selected.
+func (s *Selector) Match(secret v1.Secret) bool {
+	if len(s.Fingerprints) > 0 {
+		fingerprint, err := kubeseal.CertificateFingerprint(secret)
+		if err != nil {
+			return false
+		}
+		for _, prefix := range s.Fingerprints {
+			if strings.HasPrefix(fingerprint, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	if !s.Until.IsZero() {
+		cert, err := kubeseal.ParseCertificate(secret)
+		if err != nil {
+			return false
+		}
+		return !cert.NotBefore.After(s.Until)
+	}
+	return true
+}
+
+// objectKeys - List the objects which may hold keys of the controller of the cluster, from the catalog when there is
+// one.
+func objectKeys(ctx context.Context, state *config.State, b backend.Backend) ([]string, error) {
+	var keys []string
+	if state.Config.CatalogName != "" {
+		c, err := catalog.Load(ctx, b, state.Config.CatalogName)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range c.Keys {
+			if entry.Owned(state.Config.KubesealControllerNamespace, state.Config.KubesealControllerName, state.Config.ClusterName) {
+				keys = append(keys, entry.Key)
+			}
+		}
+		if len(keys) > 0 {
+			return keys, nil
+		}
+	}
+	objects, err := b.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		// Objects tagged with another cluster are never restored.
+		cluster, tagged := object.Metadata["cluster"]
+		if tagged && cluster != state.Config.ClusterName {
+			continue
+		}
+		if object.Key != state.Config.CatalogName {
+			keys = append(keys, object.Key)
+		}
+	}
+	return keys, nil
+}
+
+// readSecrets - Download, decrypt and decode every secret of an object.
+func readSecrets(ctx context.Context, state *config.State, b backend.Backend, key string) ([]v1.Secret, error) {
+	reader, err := b.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return DecodeSecrets(state, key, reader)
+}
+
+// DecodeSecrets - Decrypt the content of the object key and decode every secret it holds.
+func DecodeSecrets(state *config.State, key string, content io.Reader) ([]v1.Secret, error) {
+	payload, err := encryptionutils.DecryptPayload(state, key, content)
+	if err != nil {
+		return nil, err
+	}
+	var secrets []v1.Secret
+	decoder := yaml.NewYAMLOrJSONDecoder(payload, 4096)
+	for {
+		var secret v1.Secret
+		err = decoder.Decode(&secret)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if secret.Kind == "Secret" {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets, nil
+}
+
+// FetchKeys - Utils to read the kubeseal keys stored in the backend, from the oldest to the newest. Keys found in
+// several objects (individual backups and bundles) are returned once.
+func FetchKeys(ctx context.Context, state *config.State, b backend.Backend) []v1.Secret {
+	keys, err := objectKeys(ctx, state, b)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err.Error(),
+			"backend": state.Config.Backend,
+		}).Error("Unable to list backed-up keys")
+		os.Exit(1)
+	}
+
+	seen := map[string]bool{}
+	var secrets []v1.Secret
+	for _, key := range keys {
+		found, err := readSecrets(ctx, state, b, key)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":    err.Error(),
+				"filename": key,
+			}).Error("Unable to read backed-up key")
+			continue
+		}
+		for _, secret := range found {
+			if !strings.HasPrefix(secret.Name, state.Config.KubesealKeyPrefix) || seen[secret.Name] {
+				continue
+			}
+			seen[secret.Name] = true
+			secrets = append(secrets, secret)
+		}
+	}
+	sort.SliceStable(secrets, func(i, j int) bool {
+		return notBefore(secrets[i]).Before(notBefore(secrets[j]))
+	})
+	return secrets
+}
+
+// notBefore - Issue date of the key certificate, the creation date is not part of the backups.
+func notBefore(secret v1.Secret) time.Time {
+	cert, err := kubeseal.ParseCertificate(secret)
+	if err != nil {
+		return time.Time{}
+	}
+	return cert.NotBefore
+}
+
+// LatestActive - Issue date of the newest certificate among the active keys, zero when there is none.
+func LatestActive(secrets []v1.Secret) time.Time {
+	var latest time.Time
+	for _, secret := range secrets {
+		if secret.Labels[kubesealSecretLabel] != "active" {
+			continue
+		}
+		if issued := notBefore(secret); issued.After(latest) {
+			latest = issued
+		}
+	}
+	return latest
+}
+
+// restoredStatuses - Status label of each selected key once restored. Only the newest key among the selected ones and
+// the active keys of the cluster is active, so the controller seals with the same key whether the cluster is empty or
+// not, the older ones are restored as compromised.
+func restoredStatuses(existing []v1.Secret, selected []v1.Secret) map[string]string {
+	latest := LatestActive(existing)
+	for _, secret := range selected {
+		if issued := notBefore(secret); issued.After(latest) {
+			latest = issued
+		}
+	}
+	statuses := make(map[string]string, len(selected))
+	for _, secret := range selected {
+		statuses[secret.Name] = "active"
+		if notBefore(secret).Before(latest) {
+			statuses[secret.Name] = "compromised"
+		}
+	}
+	return statuses
+}
+
+// RestoreKeys - Utils to re-create the keys selected by RESTORE_KEYS in the controller namespace and restart the
+// controller. Keys which already exist in the cluster are left untouched. Restored keys get a new creation date, which
+// the controller uses to pick the sealing key, so only the newest key is restored as active and the older ones as
+// compromised: they still decrypt but the controller keeps sealing with the newest key. It returns the number of
+// restored keys.
+func RestoreKeys(ctx context.Context, state *config.State, b backend.Backend) int {
+	selector, err := ParseSelector(state.Config.RestoreKeys)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Error("Invalid RESTORE_KEYS")
+		os.Exit(1)
+	}
+
+	var selected []v1.Secret
+	for _, secret := range FetchKeys(ctx, state, b) {
+		if selector.Match(secret) {
+			selected = append(selected, secret)
+		}
+	}
+	if len(selected) == 0 {
+		log.WithFields(log.Fields{
+			"keys":    state.Config.RestoreKeys,
+			"backend": state.Config.Backend,
+		}).Error("No backed-up key matches the selection")
+		os.Exit(1)
+	}
+
+	namespace := state.Config.KubesealControllerNamespace
+	// The cluster may hold no key at all when it has been rebuilt.
+	existing, err := state.K8s.SearchSecrets(namespace, metav1.ListOptions{
+		LabelSelector: kubesealSecretLabel,
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":     err.Error(),
+			"namespace": namespace,
+		}).Error("Unable to list kubeseal keys")
+		os.Exit(1)
+	}
+	statuses := restoredStatuses(existing.Items, selected)
+
+	restored := 0
+	for _, secret := range selected {
+		_, err := state.K8s.GetSecret(namespace, secret.Name)
+		if err == nil {
+			log.WithFields(log.Fields{
+				"secret":    secret.Name,
+				"namespace": namespace,
+			}).Info("Key already exists in the cluster, skipping")
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			log.WithFields(log.Fields{
+				"error":  err.Error(),
+				"secret": secret.Name,
+			}).Error("Unable to check if the key exists in the cluster")
+			os.Exit(1)
+		}
+
+		labels := map[string]string{}
+		for k, v := range secret.Labels {
+			labels[k] = v
+		}
+		labels[kubesealSecretLabel] = statuses[secret.Name]
+		_, err = state.K8s.CreateSecret(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        secret.Name,
+				Namespace:   namespace,
+				Labels:      labels,
+				Annotations: secret.Annotations,
+			},
+			Type: secret.Type,
+			Data: secret.Data,
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":  err.Error(),
+				"secret": secret.Name,
+			}).Error("Unable to restore key")
+			os.Exit(1)
+		}
+		log.WithFields(log.Fields{
+			"secret":    secret.Name,
+			"namespace": namespace,
+			"status":    labels[kubesealSecretLabel],
+		}).Info("Key has been restored")
+		restored++
+	}
+
+	if restored > 0 {
+		k8sutils.RestartKubeseal(state)
+	}
+	return restored
+}
@@ -0,0 +1,137 @@
+package restoreutils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    *Selector
+		wantErr bool
+	}{
+		{value: "", want: &Selector{}},
+		{value: "all", want: &Selector{}},
+		{value: "fingerprint:ABCD", want: &Selector{Fingerprints: []string{"abcd"}}},
+		{value: "fingerprint:ab:cd, ef01,", want: &Selector{Fingerprints: []string{"abcd", "ef01"}}},
+		{value: "fingerprint:", wantErr: true},
+		{value: "date:2021-01-02", want: &Selector{Until: time.Date(2021, 1, 2, 23, 59, 59, 999999999, time.UTC)}},
+		{value: "date:2021-01-02T10:00:00Z", want: &Selector{Until: time.Date(2021, 1, 2, 10, 0, 0, 0, time.UTC)}},
+		{value: "date:yesterday", wantErr: true},
+		{value: "latest", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSelector(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSelector(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSelector(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+// keySecret - A kubeseal secret whose certificate was issued at notBefore.
+func keySecret(t *testing.T, name string, notBefore time.Time, status string) v1.Secret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sealed-secret"},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{kubesealSecretLabel: status},
+		},
+		Data: map[string][]byte{
+			v1.TLSCertKey: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		},
+	}
+}
+
+func TestLatestActive(t *testing.T) {
+	t0 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := keySecret(t, "sealed-secrets-keya", t0, "active")
+	current := keySecret(t, "sealed-secrets-keyb", t0.Add(24*time.Hour), "active")
+	compromised := keySecret(t, "sealed-secrets-keyc", t0.Add(48*time.Hour), "compromised")
+
+	tests := []struct {
+		name    string
+		secrets []v1.Secret
+		want    time.Time
+	}{
+		{name: "no key", want: time.Time{}},
+		{name: "only compromised keys", secrets: []v1.Secret{compromised}, want: time.Time{}},
+		{name: "newest active key", secrets: []v1.Secret{current, old, compromised}, want: t0.Add(24 * time.Hour)},
+	}
+	for _, tt := range tests {
+		if got := LatestActive(tt.secrets); !got.Equal(tt.want) {
+			t.Errorf("%s: LatestActive = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRestoredStatuses(t *testing.T) {
+	t0 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := keySecret(t, "sealed-secrets-keya", t0, "active")
+	middle := keySecret(t, "sealed-secrets-keyb", t0.Add(24*time.Hour), "active")
+	newest := keySecret(t, "sealed-secrets-keyc", t0.Add(48*time.Hour), "compromised")
+	current := keySecret(t, "sealed-secrets-keyd", t0.Add(36*time.Hour), "active")
+
+	tests := []struct {
+		name     string
+		existing []v1.Secret
+		selected []v1.Secret
+		want     map[string]string
+	}{
+		{
+			name:     "empty cluster",
+			selected: []v1.Secret{old, newest, middle},
+			want:     map[string]string{old.Name: "compromised", middle.Name: "compromised", newest.Name: "active"},
+		},
+		{
+			name:     "single key into an empty cluster",
+			selected: []v1.Secret{old},
+			want:     map[string]string{old.Name: "active"},
+		},
+		{
+			name:     "older than the active key of the cluster",
+			existing: []v1.Secret{current},
+			selected: []v1.Secret{old, middle},
+			want:     map[string]string{old.Name: "compromised", middle.Name: "compromised"},
+		},
+		{
+			name:     "newer than the active key of the cluster",
+			existing: []v1.Secret{current},
+			selected: []v1.Secret{middle, newest},
+			want:     map[string]string{middle.Name: "compromised", newest.Name: "active"},
+		},
+	}
+	for _, tt := range tests {
+		if got := restoredStatuses(tt.existing, tt.selected); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: restoredStatuses = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}